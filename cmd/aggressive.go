@@ -0,0 +1,158 @@
+package cmd
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	mdns "github.com/miekg/dns"
+	"github.com/spf13/viper"
+)
+
+// proofSeen remembers when a denial of existence proof was first seen
+type proofSeen struct {
+	Qname string
+	TTL   uint32
+	Seen  time.Time
+}
+
+// aggressiveKey identifies the resolver of a probe per address family
+type aggressiveKey struct {
+	PrbID int
+	Af    int
+}
+
+// probeAggressive is the aggressive negative caching (RFC 8198) state of
+// the resolver of one probe and address family
+type probeAggressive struct {
+	MaxTTL     uint32
+	Denial     string
+	Aggressive bool
+	Proofs     map[string]proofSeen
+}
+
+// aggressiveCount is the number of responses per denial type
+type aggressiveCount struct {
+	Responses     int
+	Synthesized   int
+	SynthesizedAD int
+}
+
+var aggressiveState = make(map[aggressiveKey]*probeAggressive)
+var aggressiveStats = make(map[string]*aggressiveCount)
+var authNegTTL uint32
+var accessAggressive = sync.Mutex{}
+
+// updateAggressive checks if a response to a random name was synthesized
+// from cached NSEC or NSEC3 records.
+//
+// Random names are never cached, a fresh answer always carries the full
+// negative TTL. A lower TTL or a proof that was seen for another name with
+// a higher TTL means that the answer came from the cache.
+func updateAggressive(prbid int, af int, msg *mdns.Msg, ts time.Time) {
+	denial := nsec(msg.Ns)
+	if denial == NONSEC {
+		return
+	}
+	s := soa(msg.Ns)
+	if s == nil || len(msg.Question) == 0 {
+		return
+	}
+	ttl := s.Hdr.Ttl
+	if s.Minttl < ttl {
+		ttl = s.Minttl
+	}
+	qname := msg.Question[0].Name
+	signature := denialSignature(msg.Ns)
+
+	accessAggressive.Lock()
+	defer accessAggressive.Unlock()
+
+	// authoritative negative ttl from config or largest seen
+	if viper.GetUint32("negttl") > 0 {
+		authNegTTL = viper.GetUint32("negttl")
+	} else if ttl > authNegTTL {
+		authNegTTL = ttl
+	}
+
+	key := aggressiveKey{PrbID: prbid, Af: af}
+	pa, ok := aggressiveState[key]
+	if !ok {
+		pa = &probeAggressive{Proofs: make(map[string]proofSeen)}
+		aggressiveState[key] = pa
+	}
+	pa.Denial = denial
+
+	// resolvers may cap the negative ttl, the first response
+	// of a probe is the baseline
+	synthesized := pa.MaxTTL > 0 && ttl < authNegTTL && ttl < pa.MaxTTL
+	if ttl > pa.MaxTTL {
+		pa.MaxTTL = ttl
+	}
+
+	// forget proofs that must have expired from the cache
+	for sig, p := range pa.Proofs {
+		if p.Seen.Add(time.Duration(p.TTL) * time.Second).Before(ts) {
+			delete(pa.Proofs, sig)
+		}
+	}
+
+	if p, ok := pa.Proofs[signature]; ok {
+		if p.Qname != qname && ttl < p.TTL {
+			synthesized = true
+		}
+	} else {
+		pa.Proofs[signature] = proofSeen{Qname: qname, TTL: ttl, Seen: ts}
+	}
+
+	c, ok := aggressiveStats[denial]
+	if !ok {
+		c = &aggressiveCount{}
+		aggressiveStats[denial] = c
+	}
+	c.Responses++
+	if !synthesized {
+		return
+	}
+	c.Synthesized++
+	if msg.AuthenticatedData {
+		c.SynthesizedAD++
+	}
+	if !pa.Aggressive && verbose > 1 {
+		log.Printf("Probe %d IPv%d does aggressive %s caching", prbid, af, denial)
+	}
+	pa.Aggressive = true
+}
+
+// addAggressivePoints adds the share of resolvers doing aggressive negative
+// caching, one point per denial type. Probes are counted per address family.
+func addAggressivePoints(bp *Batch, now time.Time) {
+	accessAggressive.Lock()
+	defer accessAggressive.Unlock()
+
+	probes := make(map[string]int)
+	aggressive := make(map[string]int)
+	for _, pa := range aggressiveState {
+		probes[pa.Denial]++
+		if pa.Aggressive {
+			aggressive[pa.Denial]++
+		}
+	}
+
+	for _, denial := range []string{NSEC, NSEC3} {
+		fields := map[string]interface{}{
+			"probes":     probes[denial],
+			"aggressive": aggressive[denial],
+			"negttl":     int(authNegTTL),
+		}
+		if probes[denial] > 0 {
+			fields["share"] = float64(aggressive[denial]) / float64(probes[denial])
+		}
+		if c, ok := aggressiveStats[denial]; ok {
+			fields["responses"] = c.Responses
+			fields["synthesized"] = c.Synthesized
+			fields["synthesized_ad"] = c.SynthesizedAD
+		}
+		addPoint(bp, "randomAggressive", map[string]string{"denial": denial}, fields, now)
+	}
+}
@@ -0,0 +1,320 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	INACTIVE = "inactive"
+	PENDING  = "pending"
+	FIRING   = "firing"
+	RESOLVED = "resolved"
+)
+
+// alertRule is one rule from the "alerts" section of the config file
+//
+//	alerts:
+//	  - name: static-servfail
+//	    expr: ratio(staticRcodes.SERVFAIL) > 5%
+//	    for: 3
+//	    resolve: 3
+//
+// Expressions are <func>(<measurement>.<field>{tag="value",...}) <op> <threshold>.
+// value() is the sum of the field over all matching points, increase() is the
+// change of that sum since the last snapshot and ratio() (or share()) is the
+// increase of the field divided by the increase of the count fields of the
+// points. The count fields are given with "of", e.g.
+// ratio(staticRcodes.SERVFAIL of NOERROR,NXDOMAIN,SERVFAIL), without "of"
+// all integer fields are counted. Without matching points the value is 0.
+// Sums start at 0 and a smaller sum than before is no increase.
+type alertRule struct {
+	Name    string `mapstructure:"name"`
+	Expr    string `mapstructure:"expr"`
+	For     int    `mapstructure:"for"`
+	Resolve int    `mapstructure:"resolve"`
+
+	function    string
+	measurement string
+	field       string
+	tags        map[string]string
+	of          []string
+	op          string
+	threshold   float64
+}
+
+// Alert is the state of one rule
+type Alert struct {
+	Name      string    `json:"name"`
+	Expr      string    `json:"expr"`
+	State     string    `json:"state"`
+	Value     float64   `json:"value"`
+	Threshold float64   `json:"threshold"`
+	Since     time.Time `json:"since"`
+	hits      int
+	misses    int
+}
+
+var alertRules []*alertRule
+var alertState = make(map[string]*Alert)
+var alertLast = make(map[string]float64)
+var alertInit = sync.Once{}
+var accessAlerts = sync.Mutex{}
+
+var exprRegexp = regexp.MustCompile(`^\s*(value|increase|ratio|share)\(\s*([A-Za-z0-9_]+)\.([A-Za-z0-9_\-]+)\s*(\{[^}]*\})?\s*(?:of\s+([A-Za-z0-9_\-,\s]+?))?\s*\)\s*(>=|<=|==|!=|>|<)\s*([0-9.eE+\-]+)(%?)\s*$`)
+var tagRegexp = regexp.MustCompile(`([A-Za-z0-9_]+)\s*=\s*"([^"]*)"`)
+
+// loadAlertRules reads and parses the rules from the config file
+func loadAlertRules() {
+	rules := make([]*alertRule, 0)
+	if err := viper.UnmarshalKey("alerts", &rules); err != nil {
+		log.Fatal("Could not read alert rules. ", err)
+	}
+	for _, rule := range rules {
+		if err := rule.parse(); err != nil {
+			log.Fatalf("Alert rule %s: %s", rule.Name, err)
+		}
+		if rule.For < 1 {
+			rule.For = 1
+		}
+		if rule.Resolve < 1 {
+			rule.Resolve = rule.For
+		}
+		alertState[rule.Name] = &Alert{Name: rule.Name, Expr: rule.Expr, State: INACTIVE, Threshold: rule.threshold}
+	}
+	alertRules = rules
+	if verbose > 0 {
+		log.Printf("Loaded %d alert rules", len(alertRules))
+	}
+}
+
+// parse parses the expression of the rule
+func (r *alertRule) parse() error {
+	m := exprRegexp.FindStringSubmatch(r.Expr)
+	if m == nil {
+		return fmt.Errorf("could not parse expression %s", r.Expr)
+	}
+	r.function = m[1]
+	if r.function == "share" {
+		r.function = "ratio"
+	}
+	r.measurement = m[2]
+	r.field = m[3]
+	r.tags = make(map[string]string)
+	for _, t := range tagRegexp.FindAllStringSubmatch(m[4], -1) {
+		r.tags[t[1]] = t[2]
+	}
+	for _, f := range strings.Split(m[5], ",") {
+		if f = strings.TrimSpace(f); len(f) > 0 {
+			r.of = append(r.of, f)
+		}
+	}
+	if len(r.of) > 0 && r.function != "ratio" {
+		return fmt.Errorf("of is only allowed for ratio")
+	}
+	r.op = m[6]
+	threshold, err := strconv.ParseFloat(m[7], 64)
+	if err != nil {
+		return err
+	}
+	if m[8] == "%" {
+		threshold = threshold / 100
+	}
+	r.threshold = threshold
+	return nil
+}
+
+// compare applies the operator of the rule
+func (r *alertRule) compare(v float64) bool {
+	switch r.op {
+	case ">":
+		return v > r.threshold
+	case ">=":
+		return v >= r.threshold
+	case "<":
+		return v < r.threshold
+	case "<=":
+		return v <= r.threshold
+	case "==":
+		return v == r.threshold
+	case "!=":
+		return v != r.threshold
+	}
+	return false
+}
+
+// matches checks if the point is used by the rule
+func (r *alertRule) matches(name string, tags map[string]string) bool {
+	if name != r.measurement {
+		return false
+	}
+	for k, v := range r.tags {
+		if tags[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// evaluateAlerts evaluates all rules on the points of a stats snapshot
+func evaluateAlerts(bp *Batch, now time.Time) {
+	alertInit.Do(loadAlertRules)
+
+	accessAlerts.Lock()
+	defer accessAlerts.Unlock()
+
+	for _, rule := range alertRules {
+		updateAlert(rule, rule.evaluate(bp), now)
+	}
+}
+
+// evaluate computes the value of the rule, 0 if no point matched.
+// Counters are cumulative, the sums over all matching points are
+// compared to the last snapshot so probes moving between tags do not
+// change the result.
+func (r *alertRule) evaluate(bp *Batch) float64 {
+	var field, total float64
+
+	for _, pt := range bp.Points {
+		if !r.matches(pt.Name, pt.Tags) {
+			continue
+		}
+		v, ok := number(pt.Fields[r.field])
+		if !ok {
+			continue
+		}
+		field += v
+		total += r.count(pt.Fields)
+	}
+
+	switch r.function {
+	case "increase":
+		return alertDelta(r.Name+" field", field)
+	case "ratio":
+		field = alertDelta(r.Name+" field", field)
+		total = alertDelta(r.Name+" total", total)
+		if total == 0 {
+			return 0
+		}
+		return field / total
+	}
+	return field
+}
+
+// count sums the count fields of a point, the fields given with "of"
+// or all integer fields
+func (r *alertRule) count(fields map[string]interface{}) float64 {
+	sum := 0.0
+	if len(r.of) > 0 {
+		for _, f := range r.of {
+			if n, ok := number(fields[f]); ok {
+				sum += n
+			}
+		}
+		return sum
+	}
+	for _, f := range fields {
+		switch n := f.(type) {
+		case int:
+			sum += float64(n)
+		case int64:
+			sum += float64(n)
+		}
+	}
+	return sum
+}
+
+// updateAlert moves the alert state, an alert fires after "for" and is
+// resolved after "resolve" consecutive snapshots
+func updateAlert(rule *alertRule, value float64, now time.Time) {
+	a := alertState[rule.Name]
+	a.Value = value
+
+	if rule.compare(value) {
+		a.hits++
+		a.misses = 0
+	} else {
+		a.misses++
+		a.hits = 0
+	}
+
+	switch a.State {
+	case INACTIVE, RESOLVED:
+		if a.hits > 0 {
+			a.State = PENDING
+			a.Since = now
+		}
+		if a.hits >= rule.For {
+			a.State = FIRING
+			a.Since = now
+			alertChanged(a)
+		}
+	case PENDING:
+		if a.hits >= rule.For {
+			a.State = FIRING
+			a.Since = now
+			alertChanged(a)
+		} else if a.hits == 0 {
+			a.State = INACTIVE
+			a.Since = now
+		}
+	case FIRING:
+		if a.misses >= rule.Resolve {
+			a.State = RESOLVED
+			a.Since = now
+			alertChanged(a)
+		}
+	}
+}
+
+// alertChanged is called once when an alert fires or is resolved
+func alertChanged(a *Alert) {
+	log.Printf("ALERT %s %s: %s is %g (threshold %g)", a.Name, a.State, a.Expr, a.Value, a.Threshold)
+	notify(a)
+}
+
+// alertDelta returns the increase of a sum since the last snapshot,
+// sums start at 0 and a smaller sum is no increase
+func alertDelta(id string, v float64) float64 {
+	last := alertLast[id]
+	if v <= last {
+		return 0
+	}
+	alertLast[id] = v
+	return v - last
+}
+
+// pointID identifies a series by measurement name and tags
+func pointID(name string, tags map[string]string) string {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	id := name
+	for _, k := range keys {
+		id += "," + k + "=" + tags[k]
+	}
+	return id
+}
+
+// number converts a field value to float64
+func number(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
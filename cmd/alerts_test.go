@@ -0,0 +1,224 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAlertParse(t *testing.T) {
+	tests := []struct {
+		expr      string
+		ok        bool
+		function  string
+		field     string
+		tags      map[string]string
+		of        []string
+		op        string
+		threshold float64
+	}{
+		{"ratio(staticRcodes.SERVFAIL) > 5%", true, "ratio", "SERVFAIL", map[string]string{}, nil, ">", 0.05},
+		{"share(invalidValidation.validating) <= 0.5", true, "ratio", "validating", map[string]string{}, nil, "<=", 0.5},
+		{`increase(authRcodes.NOERROR{server="a.ns.se",af="6"}) < 1`, true, "increase", "NOERROR", map[string]string{"server": "a.ns.se", "af": "6"}, nil, "<", 1},
+		{"ratio(staticRcodes.SERVFAIL of NOERROR, NXDOMAIN,SERVFAIL) >= 1e-2", true, "ratio", "SERVFAIL", map[string]string{}, []string{"NOERROR", "NXDOMAIN", "SERVFAIL"}, ">=", 0.01},
+		{"value(sinkBuffer.batches) != 0", true, "value", "batches", map[string]string{}, nil, "!=", 0},
+		{"increase(staticRcodes.SERVFAIL of NOERROR) > 1", false, "", "", nil, nil, "", 0},
+		{"rate(staticRcodes.SERVFAIL) > 1", false, "", "", nil, nil, "", 0},
+		{"ratio(staticRcodes) > 1", false, "", "", nil, nil, "", 0},
+		{"ratio(staticRcodes.SERVFAIL) >> 1", false, "", "", nil, nil, "", 0},
+		{"ratio(staticRcodes.SERVFAIL) > x", false, "", "", nil, nil, "", 0},
+	}
+	for _, tt := range tests {
+		r := &alertRule{Expr: tt.expr}
+		err := r.parse()
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: got error %v", tt.expr, err)
+			continue
+		}
+		if !tt.ok {
+			continue
+		}
+		if r.function != tt.function || r.field != tt.field || r.op != tt.op || r.threshold != tt.threshold {
+			t.Errorf("%s: got %s %s %s %g", tt.expr, r.function, r.field, r.op, r.threshold)
+		}
+		if len(r.tags) != len(tt.tags) {
+			t.Errorf("%s: got tags %v", tt.expr, r.tags)
+		}
+		for k, v := range tt.tags {
+			if r.tags[k] != v {
+				t.Errorf("%s: got tags %v", tt.expr, r.tags)
+			}
+		}
+		if len(r.of) != len(tt.of) {
+			t.Errorf("%s: got of %v", tt.expr, r.of)
+		}
+		for i := range tt.of {
+			if i < len(r.of) && r.of[i] != tt.of[i] {
+				t.Errorf("%s: got of %v", tt.expr, r.of)
+			}
+		}
+	}
+}
+
+// rcodePoint is a staticRcodes point of one probe group
+func rcodePoint(country string, noerror, servfail int) *Point {
+	return &Point{
+		Name:   "staticRcodes",
+		Tags:   map[string]string{"country": country},
+		Fields: map[string]interface{}{"NOERROR": noerror, "SERVFAIL": servfail, "share": 0.5},
+	}
+}
+
+func TestAlertEvaluate(t *testing.T) {
+	tests := []struct {
+		name    string
+		expr    string
+		batches [][]*Point
+		want    []float64
+	}{
+		{
+			"ratio from the first snapshot",
+			"ratio(staticRcodes.SERVFAIL) > 5%",
+			[][]*Point{
+				{rcodePoint("unknown", 90, 10)},
+				{rcodePoint("unknown", 180, 20)},
+			},
+			[]float64{0.1, 0.1},
+		},
+		{
+			"probes moving to another group",
+			"ratio(staticRcodes.SERVFAIL) > 5%",
+			[][]*Point{
+				{rcodePoint("unknown", 100, 0)},
+				{rcodePoint("unknown", 10, 0), rcodePoint("SE", 100, 0)},
+			},
+			[]float64{0, 0},
+		},
+		{
+			"groups appearing later",
+			"increase(staticRcodes.SERVFAIL) > 0",
+			[][]*Point{
+				{rcodePoint("SE", 100, 5)},
+				{rcodePoint("SE", 100, 5), rcodePoint("NO", 10, 2)},
+			},
+			[]float64{5, 2},
+		},
+		{
+			"smaller sum is no increase",
+			"increase(staticRcodes.NOERROR) < 1",
+			[][]*Point{
+				{rcodePoint("SE", 100, 0)},
+				{rcodePoint("SE", 50, 0)},
+				{rcodePoint("SE", 110, 0)},
+			},
+			[]float64{100, 0, 10},
+		},
+		{
+			"tag filter",
+			`increase(staticRcodes.SERVFAIL{country="SE"}) > 0`,
+			[][]*Point{
+				{rcodePoint("SE", 100, 5), rcodePoint("NO", 100, 50)},
+			},
+			[]float64{5},
+		},
+		{
+			"of names the count fields",
+			"ratio(staticRcodes.SERVFAIL of SERVFAIL) > 0",
+			[][]*Point{
+				{rcodePoint("SE", 100, 5)},
+			},
+			[]float64{1},
+		},
+		{
+			"value is not a delta",
+			"value(staticRcodes.SERVFAIL) > 0",
+			[][]*Point{
+				{rcodePoint("SE", 100, 5), rcodePoint("NO", 100, 2)},
+				{rcodePoint("SE", 100, 5), rcodePoint("NO", 100, 2)},
+			},
+			[]float64{7, 7},
+		},
+		{
+			"no points",
+			"increase(staticRcodes.NOERROR) < 1",
+			[][]*Point{
+				{rcodePoint("SE", 100, 0)},
+				{},
+				{{Name: "randomRcodes", Fields: map[string]interface{}{"NOERROR": 1000}}},
+			},
+			[]float64{100, 0, 0},
+		},
+	}
+	for _, tt := range tests {
+		alertLast = make(map[string]float64)
+		r := &alertRule{Name: tt.name, Expr: tt.expr}
+		if err := r.parse(); err != nil {
+			t.Fatal(err)
+		}
+		for i, points := range tt.batches {
+			if got := r.evaluate(&Batch{Points: points}); got != tt.want[i] {
+				t.Errorf("%s: snapshot %d got %g, want %g", tt.name, i, got, tt.want[i])
+			}
+		}
+	}
+}
+
+func TestUpdateAlert(t *testing.T) {
+	tests := []struct {
+		name    string
+		for_    int
+		resolve int
+		values  []float64
+		states  []string
+	}{
+		{
+			"fires at once",
+			1, 1,
+			[]float64{0, 1, 1, 0, 0},
+			[]string{INACTIVE, FIRING, FIRING, RESOLVED, RESOLVED},
+		},
+		{
+			"pending until for",
+			3, 2,
+			[]float64{1, 1, 1, 1, 0, 1, 0, 0},
+			[]string{PENDING, PENDING, FIRING, FIRING, FIRING, FIRING, FIRING, RESOLVED},
+		},
+		{
+			"pending falls back",
+			2, 2,
+			[]float64{1, 0, 1, 0},
+			[]string{PENDING, INACTIVE, PENDING, INACTIVE},
+		},
+		{
+			"fires again after resolve",
+			1, 1,
+			[]float64{1, 0, 1},
+			[]string{FIRING, RESOLVED, FIRING},
+		},
+		{
+			"resolved then pending",
+			2, 1,
+			[]float64{1, 1, 0, 1, 1},
+			[]string{PENDING, FIRING, RESOLVED, PENDING, FIRING},
+		},
+	}
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, tt := range tests {
+		r := &alertRule{Name: tt.name, Expr: "value(m.f) > 0.5", For: tt.for_, Resolve: tt.resolve}
+		if err := r.parse(); err != nil {
+			t.Fatal(err)
+		}
+		alertState[r.Name] = &Alert{Name: r.Name, Expr: r.Expr, State: INACTIVE, Threshold: r.threshold}
+		for i, v := range tt.values {
+			ts := now.Add(time.Duration(i) * time.Minute)
+			updateAlert(r, v, ts)
+			a := alertState[r.Name]
+			if a.State != tt.states[i] {
+				t.Errorf("%s: step %d got %s, want %s", tt.name, i, a.State, tt.states[i])
+			}
+			if a.Value != v {
+				t.Errorf("%s: step %d got value %g", tt.name, i, a.Value)
+			}
+		}
+		delete(alertState, r.Name)
+	}
+}
@@ -19,23 +19,35 @@ package cmd
 import (
 	"log"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/DNS-OARC/ripeatlas/measurement"
 	"github.com/DNS-OARC/ripeatlas/measurement/dns"
 
-	_ "github.com/influxdata/influxdb1-client" // this is important because of the bug in go mod
-	client "github.com/influxdata/influxdb1-client/v2"
-
-	mdns "github.com/miekg/dns"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
-var statsAuthRcode [32]int
-var statsAuthNSEC int
-var statsAuthNSEC3 int
-var statsAuthNONSEC int
+// authKey identifies an authoritative server as seen from one probe
+type authKey struct {
+	Server string
+	Addr   string
+	Af     int
+	PrbID  int
+}
+
+// authGroup identifies an authoritative server as seen from the probes
+// with the same metadata
+type authGroup struct {
+	Server string
+	Addr   string
+	Af     int
+	Probe  probeKey
+}
+
+var statsAuth = make(map[authKey]*rcodeCount)
+var accessAuth = sync.Mutex{}
 
 // authCmd represents the auth command
 var authCmd = &cobra.Command{
@@ -83,12 +95,7 @@ func runAuth(cmd *cobra.Command, args []string) {
 }
 
 func checkAuthConf() {
-	if len(viper.GetString("influxserver")) == 0 {
-		log.Println("Influx server must be given")
-	}
-	if len(viper.GetString("influxdb")) == 0 {
-		log.Println("Influx database must be given")
-	}
+	checkSinkConf()
 }
 
 func rcvAuth(ch <-chan *measurement.Result) {
@@ -99,6 +106,7 @@ func rcvAuth(ch <-chan *measurement.Result) {
 		// if parsing fails
 		if msm.ParseError != nil {
 			log.Println(msm.ParseError.Error())
+			addError("auth", PARSEERROR)
 			continue
 		}
 
@@ -113,35 +121,37 @@ func rcvAuth(ch <-chan *measurement.Result) {
 			log.Printf("%d %s", msm.MsmId(), msm.Type())
 		}
 
-		// handle single result
-		if msm.DnsResult() != nil {
-			handleAuth(msm.DnsResult())
-		}
-		for _, s := range msm.DnsResultsets() {
-			if s.Result() != nil {
-				handleAuth(s.Result())
-			}
-		}
+		// handle the result once its window is closed
+		addResult(msm, func(msm *measurement.Result, dst string, result *dns.Result) {
+			handleAuth(msm, result)
+		})
 	}
 
 }
 
-func handleAuth(result *dns.Result) {
+func handleAuth(msm *measurement.Result, result *dns.Result) {
 
 	msg, err := result.UnpackAbuf()
 	if err != nil {
 		log.Println("Could not unpack Abuf ", err)
+		addError("auth", ABUFERROR)
 		return
 	}
-	statsAuthRcode[msg.Rcode]++
-	switch nsec(msg.Ns) {
-	case NSEC:
-		statsAuthNSEC++
-	case NSEC3:
-		statsAuthNSEC3++
-	case NONSEC:
-		statsAuthNONSEC++
+	storeResponse("auth", msm, msm.DstAddr(), result, msg)
+	key := authKey{Server: server(msm), Addr: msm.DstAddr(), Af: msm.Af(), PrbID: msm.PrbId()}
+
+	accessAuth.Lock()
+	c, ok := statsAuth[key]
+	if !ok {
+		c = &rcodeCount{}
+		statsAuth[key] = c
 	}
+	c.add(msg)
+	accessAuth.Unlock()
+
+	addNsid(server(msm), msm.Af(), msg, result.Rt())
+	addRtt("auth", msm.PrbId(), msm.Af(), server(msm), result.Rt())
+	addSize("auth", msm.MsmId(), result.Size(), msg)
 }
 
 func server(msm *measurement.Result) string {
@@ -155,82 +165,45 @@ func server(msm *measurement.Result) string {
 }
 
 func saveAuthStats() {
-	// influxdb client config
-	conf := client.HTTPConfig{
-		Addr: viper.GetString("influxserver"),
-	}
-	if len(viper.GetString("influxuser")) > 0 {
-		conf.Username = viper.GetString("influxuser")
-		conf.Password = viper.GetString("influxpasswd")
-	}
-
-	// get access to Influx
-	influx, err := client.NewHTTPClient(conf)
-	if err != nil {
-		log.Fatalf("Error creating InfluxDB Client: %s", err.Error())
-	}
-
-	ticker := time.NewTicker(UPDATEINTERVAL)
-	for {
-		now := <-ticker.C
-
-		bp, err := client.NewBatchPoints(client.BatchPointsConfig{
-			Database:  viper.GetString("influxdb"),
-			Precision: "s",
-		})
-		if err != nil {
-			log.Fatalf("Could not create new batch points: %s", err.Error())
+	saveStats(func(bp *Batch, now time.Time) {
+		// group the probes by metadata
+		accessAuth.Lock()
+		group := make(map[authGroup]*rcodeCount)
+		for key, c := range statsAuth {
+			probe, ok := probeTags(key.PrbID)
+			if !ok {
+				// counted once the metadata is known
+				continue
+			}
+			g := authGroup{Server: key.Server, Addr: key.Addr, Af: key.Af, Probe: probe}
+			if _, ok := group[g]; !ok {
+				group[g] = &rcodeCount{}
+			}
+			group[g].merge(c)
 		}
+		accessAuth.Unlock()
 
-		// tags
-		tags := map[string]string{}
+		for key, c := range group {
+			// tags
+			tags := key.Probe.tags()
+			tags["server"] = key.Server
+			tags["addr"] = key.Addr
+			tags["af"] = strconv.Itoa(key.Af)
 
-		// values
-		fields := map[string]interface{}{}
-		for _, rcode := range RCODES {
-			fields[mdns.RcodeToString[rcode]] = statsAuthRcode[rcode]
+			addPoint(bp, "authRcodes", tags, c.rcodeFields(), now)
+			addPoint(bp, "authNsec", tags, c.nsecFields(), now)
 		}
 
-		// create new point
-		pt, err := client.NewPoint("authRcodes", tags, fields, now)
-		if err != nil {
-			log.Fatal("Could not create new point. ", err)
-		}
-		if verbose > 2 {
-			log.Printf("Tags:   %v\n", tags)
-			log.Printf("Fields: %v\n", fields)
-		}
+		// statistics per server instance
+		addNsidPoints(bp, now)
 
-		// add point to list
-		bp.AddPoint(pt)
+		// response times
+		addRttPoints(bp, "auth", now)
 
-		// values
-		fields = map[string]interface{}{
-			"nsec":   statsAuthNSEC,
-			"nsec3":  statsAuthNSEC3,
-			"nonsec": statsAuthNONSEC,
-		}
+		// response sizes and edns
+		addSizePoints(bp, "auth", now)
 
-		// create new point
-		pt, err = client.NewPoint("authNsec", tags, fields, now)
-		if err != nil {
-			log.Fatal("Could not create new point. ", err)
-		}
-		if verbose > 2 {
-			log.Printf("Tags:   %v\n", tags)
-			log.Printf("Fields: %v\n", fields)
-		}
-
-		// add point to list
-		bp.AddPoint(pt)
-
-		// write to database
-		if verbose > 1 {
-			log.Println("Writing to influx")
-		}
-		err = influx.Write(bp)
-		if err != nil {
-			log.Printf("Error writing to InfluxDB: %s", err.Error())
-		}
-	}
+		// errors
+		addErrorPoints(bp, "auth", now)
+	})
 }
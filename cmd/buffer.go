@@ -0,0 +1,283 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// bufferEntry is one batch that could not be written to a sink. Types
+// holds the field types of every point, JSON numbers do not tell int
+// from float and InfluxDB rejects fields that change their type.
+type bufferEntry struct {
+	Points    []*Point            `json:"points"`
+	Types     []map[string]string `json:"types"`
+	Responses []*Response         `json:"responses,omitempty"`
+}
+
+// field types kept in the buffer
+const (
+	FIELDINT    = "int"
+	FIELDFLOAT  = "float"
+	FIELDSTRING = "string"
+	FIELDBOOL   = "bool"
+)
+
+// sinkBuffer is a directory of batches waiting for a sink, one file per
+// batch. File names are sequence numbers so batches are replayed in order.
+type sinkBuffer struct {
+	access  sync.Mutex
+	dir     string
+	max     int64
+	seq     uint64
+	dropped int
+}
+
+// newSinkBuffer opens the buffer in dir, batches left from an earlier
+// run are kept. The oldest batches are dropped if the buffer grows over max bytes.
+func newSinkBuffer(dir string, max int64) (*sinkBuffer, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	b := &sinkBuffer{dir: dir, max: max}
+	files, err := b.files()
+	if err != nil {
+		return nil, err
+	}
+	if len(files) > 0 {
+		fmt.Sscanf(files[len(files)-1].Name(), "%d.json", &b.seq)
+		if verbose > 0 {
+			log.Printf("Buffer %s has %d batches from an earlier run", dir, len(files))
+		}
+	}
+	return b, nil
+}
+
+// files returns the buffered batches, oldest first
+func (b *sinkBuffer) files() ([]os.FileInfo, error) {
+	all, err := ioutil.ReadDir(b.dir)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]os.FileInfo, 0, len(all))
+	for _, f := range all {
+		if !f.IsDir() && strings.HasSuffix(f.Name(), ".json") {
+			files = append(files, f)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name() < files[j].Name() })
+	return files, nil
+}
+
+// empty checks if there are batches waiting
+func (b *sinkBuffer) empty() bool {
+	b.access.Lock()
+	defer b.access.Unlock()
+
+	files, err := b.files()
+	return err == nil && len(files) == 0
+}
+
+// push appends a batch to the buffer
+func (b *sinkBuffer) push(e *bufferEntry) error {
+	b.access.Lock()
+	defer b.access.Unlock()
+
+	b.seq++
+	if err := writeBufferEntry(filepath.Join(b.dir, fmt.Sprintf("%020d.json", b.seq)), e); err != nil {
+		return err
+	}
+	return b.trim()
+}
+
+// trim drops the oldest batches until the buffer is below max bytes
+func (b *sinkBuffer) trim() error {
+	if b.max <= 0 {
+		return nil
+	}
+	files, err := b.files()
+	if err != nil {
+		return err
+	}
+	var size int64
+	for _, f := range files {
+		size += f.Size()
+	}
+	for i := 0; size > b.max && i < len(files)-1; i++ {
+		if err = os.Remove(filepath.Join(b.dir, files[i].Name())); err != nil {
+			return err
+		}
+		size -= files[i].Size()
+		b.dropped++
+		log.Printf("Buffer %s full, dropped batch %s", b.dir, files[i].Name())
+	}
+	return nil
+}
+
+// replay writes the buffered batches in order and stops at the first error
+func (b *sinkBuffer) replay(s Sink) error {
+	b.access.Lock()
+	defer b.access.Unlock()
+
+	files, err := b.files()
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		name := filepath.Join(b.dir, f.Name())
+		e, err := readBufferEntry(name)
+		if err != nil {
+			log.Printf("Dropping unreadable batch %s: %s", name, err)
+			b.dropped++
+			os.Remove(name)
+			continue
+		}
+		responses := len(e.Responses)
+		if err = writeEntry(s, e); err != nil {
+			// keep only the points if the responses were written
+			if responses > 0 && len(e.Responses) == 0 {
+				if werr := writeBufferEntry(name, e); werr != nil {
+					log.Printf("Could not update batch %s: %s", name, werr)
+				}
+			}
+			return err
+		}
+		if err = os.Remove(name); err != nil {
+			return err
+		}
+		if verbose > 1 {
+			log.Printf("Replayed batch %s", name)
+		}
+	}
+	return nil
+}
+
+// depth returns the number and size of buffered batches
+func (b *sinkBuffer) depth() (int, int64, int) {
+	b.access.Lock()
+	defer b.access.Unlock()
+
+	files, err := b.files()
+	if err != nil {
+		return 0, 0, b.dropped
+	}
+	var size int64
+	for _, f := range files {
+		size += f.Size()
+	}
+	return len(files), size, b.dropped
+}
+
+// writeBufferEntry writes a batch with the field types of its points.
+// The file is written and renamed so a crash never leaves half a batch.
+func writeBufferEntry(name string, e *bufferEntry) error {
+	e.Types = make([]map[string]string, len(e.Points))
+	for i, p := range e.Points {
+		e.Types[i] = fieldTypes(p.Fields)
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(name+".tmp", data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(name+".tmp", name)
+}
+
+// fieldTypes returns the type of every field
+func fieldTypes(fields map[string]interface{}) map[string]string {
+	types := make(map[string]string, len(fields))
+	for k, v := range fields {
+		switch v.(type) {
+		case int, int8, int16, int32, int64, uint8, uint16, uint32, uint64:
+			types[k] = FIELDINT
+		case float32, float64:
+			types[k] = FIELDFLOAT
+		case string:
+			types[k] = FIELDSTRING
+		case bool:
+			types[k] = FIELDBOOL
+		}
+	}
+	return types
+}
+
+// readBufferEntry reads a batch and restores the field types, numbers
+// without a recorded type are floats
+func readBufferEntry(name string) (*bufferEntry, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	e := &bufferEntry{}
+	if err = d.Decode(e); err != nil {
+		return nil, err
+	}
+	for i, p := range e.Points {
+		var types map[string]string
+		if i < len(e.Types) {
+			types = e.Types[i]
+		}
+		for k, v := range p.Fields {
+			n, ok := v.(json.Number)
+			if !ok {
+				continue
+			}
+			if types[k] == FIELDINT {
+				if p.Fields[k], err = n.Int64(); err != nil {
+					return nil, fmt.Errorf("field %s: %s", k, err)
+				}
+				continue
+			}
+			if p.Fields[k], err = n.Float64(); err != nil {
+				return nil, fmt.Errorf("field %s: %s", k, err)
+			}
+		}
+	}
+	return e, nil
+}
+
+// writeEntry writes the responses and points of a batch to the sink.
+// Written responses are removed from the batch so a batch whose points
+// failed does not write them again.
+func writeEntry(s Sink, e *bufferEntry) error {
+	if store, ok := s.(ResponseStore); ok && len(e.Responses) > 0 {
+		if err := store.WriteResponses(e.Responses); err != nil {
+			return err
+		}
+		e.Responses = nil
+	}
+	if len(e.Points) > 0 {
+		return s.Write(e.Points)
+	}
+	return nil
+}
+
+// addBufferPoints adds the depth of every sink buffer
+func addBufferPoints(bp *Batch, now time.Time) {
+	for _, s := range sinks {
+		if s.buffer == nil {
+			continue
+		}
+		batches, size, dropped := s.buffer.depth()
+
+		// values
+		fields := map[string]interface{}{
+			"batches": batches,
+			"bytes":   size,
+			"dropped": dropped,
+		}
+		addPoint(bp, "sinkBuffer", map[string]string{"sink": s.name}, fields, now)
+	}
+}
@@ -0,0 +1,174 @@
+package cmd
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBufferFieldTypes(t *testing.T) {
+	b, err := newSinkBuffer(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := time.Unix(1700000000, 0).UTC()
+	p := &Point{
+		Name: "staticRcodes",
+		Tags: map[string]string{"role": "static"},
+		Fields: map[string]interface{}{
+			"int":    42,
+			"int64":  int64(1) << 60,
+			"uint32": uint32(7),
+			"float":  0.25,
+			"whole":  2.0,
+			"zero":   0.0,
+			"string": "validating",
+			"bool":   true,
+		},
+		Time: ts,
+	}
+	if err = b.push(&bufferEntry{Points: []*Point{p}}); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &testSink{}
+	if err = b.replay(s); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.points) != 1 {
+		t.Fatalf("got %d points, want 1", len(s.points))
+	}
+	want := map[string]interface{}{
+		"int":    int64(42),
+		"int64":  int64(1) << 60,
+		"uint32": int64(7),
+		"float":  0.25,
+		"whole":  2.0,
+		"zero":   0.0,
+		"string": "validating",
+		"bool":   true,
+	}
+	got := s.points[0]
+	if !reflect.DeepEqual(got.Fields, want) {
+		t.Errorf("got fields %#v, want %#v", got.Fields, want)
+	}
+	if got.Name != p.Name || !reflect.DeepEqual(got.Tags, p.Tags) || !got.Time.Equal(ts) {
+		t.Errorf("got point %+v", got)
+	}
+	if lineProtocol(got, time.Second) != lineProtocol(p, time.Second) {
+		t.Errorf("line protocol changed from %s to %s", lineProtocol(p, time.Second), lineProtocol(got, time.Second))
+	}
+	if !b.empty() {
+		t.Error("buffer not empty after replay")
+	}
+}
+
+func TestBufferUntypedNumbers(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "00000000000000000001.json")
+	data := `{"points":[{"Name":"m","Tags":{},"Fields":{"a":1,"b":1.5},"Time":"2024-01-01T00:00:00Z"}]}`
+	if err := ioutil.WriteFile(name, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	e, err := readBufferEntry(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Points[0].Fields["a"] != 1.0 || e.Points[0].Fields["b"] != 1.5 {
+		t.Errorf("got fields %#v", e.Points[0].Fields)
+	}
+}
+
+// testSink records what was written, failing writes return err
+type testSink struct {
+	points    []*Point
+	responses []*Response
+	pointErr  error
+	respErr   error
+}
+
+func (s *testSink) Write(points []*Point) error {
+	if s.pointErr != nil {
+		return s.pointErr
+	}
+	s.points = append(s.points, points...)
+	return nil
+}
+
+func (s *testSink) WriteResponses(responses []*Response) error {
+	if s.respErr != nil {
+		return s.respErr
+	}
+	s.responses = append(s.responses, responses...)
+	return nil
+}
+
+func (s *testSink) Close() error {
+	return nil
+}
+
+func TestBufferResponsesWrittenOnce(t *testing.T) {
+	b, err := newSinkBuffer(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &testSink{pointErr: errors.New("points down")}
+
+	points := []*Point{{Name: "m", Fields: map[string]interface{}{"n": 1}, Time: time.Unix(10, 0)}}
+	responses := []*Response{{MsmID: 1, Role: "static", PrbID: 2, Timestamp: time.Unix(10, 0)}}
+
+	// responses written, points buffered
+	e := &bufferEntry{Points: points, Responses: responses}
+	if err = writeEntry(s, e); err == nil {
+		t.Fatal("expected error")
+	}
+	if len(e.Responses) != 0 {
+		t.Error("written responses still in batch")
+	}
+	if err = b.push(e); err != nil {
+		t.Fatal(err)
+	}
+
+	// responses of a buffered batch written, points fail again
+	if err = b.push(&bufferEntry{Points: points, Responses: responses}); err != nil {
+		t.Fatal(err)
+	}
+	if err = b.replay(s); err == nil {
+		t.Fatal("expected error")
+	}
+	if err = b.replay(s); err == nil {
+		t.Fatal("expected error")
+	}
+
+	s.pointErr = nil
+	if err = b.replay(s); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.responses) != 2 {
+		t.Errorf("got %d responses, want 2", len(s.responses))
+	}
+	if len(s.points) != 2 {
+		t.Errorf("got %d points, want 2", len(s.points))
+	}
+	if !b.empty() {
+		t.Error("buffer not empty after replay")
+	}
+}
+
+func TestBufferResponsesFail(t *testing.T) {
+	s := &testSink{respErr: errors.New("responses down")}
+	e := &bufferEntry{
+		Points:    []*Point{{Name: "m", Fields: map[string]interface{}{"n": 1}, Time: time.Unix(10, 0)}},
+		Responses: []*Response{{MsmID: 1, Role: "static", Timestamp: time.Unix(10, 0)}},
+	}
+	if err := writeEntry(s, e); err == nil {
+		t.Fatal("expected error")
+	}
+	if len(e.Responses) != 1 || len(s.points) != 0 {
+		t.Error("batch must be kept as a whole if the responses fail")
+	}
+}
@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"sync"
+	"time"
+
+	mdns "github.com/miekg/dns"
+)
+
+// rcodeCount counts rcodes and denial types of responses
+type rcodeCount struct {
+	Rcode  [32]int
+	NSEC   int
+	NSEC3  int
+	NONSEC int
+}
+
+// add counts one response
+func (c *rcodeCount) add(msg *mdns.Msg) {
+	c.Rcode[msg.Rcode]++
+	switch nsec(msg.Ns) {
+	case NSEC:
+		c.NSEC++
+	case NSEC3:
+		c.NSEC3++
+	case NONSEC:
+		c.NONSEC++
+	}
+}
+
+// merge adds the counts of o
+func (c *rcodeCount) merge(o *rcodeCount) {
+	for i := range c.Rcode {
+		c.Rcode[i] += o.Rcode[i]
+	}
+	c.NSEC += o.NSEC
+	c.NSEC3 += o.NSEC3
+	c.NONSEC += o.NONSEC
+}
+
+func (c *rcodeCount) rcodeFields() map[string]interface{} {
+	fields := map[string]interface{}{}
+	for _, rcode := range RCODES {
+		fields[mdns.RcodeToString[rcode]] = c.Rcode[rcode]
+	}
+	return fields
+}
+
+func (c *rcodeCount) nsecFields() map[string]interface{} {
+	return map[string]interface{}{
+		"nsec":   c.NSEC,
+		"nsec3":  c.NSEC3,
+		"nonsec": c.NONSEC,
+	}
+}
+
+// probeCounts holds rcode and denial type counts per probe, they are
+// grouped by probe metadata when the points are added so results that
+// arrive before the metadata is known are not counted as "unknown"
+type probeCounts struct {
+	access sync.Mutex
+	count  map[int]*rcodeCount
+}
+
+func newProbeCounts() *probeCounts {
+	return &probeCounts{count: make(map[int]*rcodeCount)}
+}
+
+// add counts one response of the probe
+func (s *probeCounts) add(prbid int, msg *mdns.Msg) {
+	s.access.Lock()
+	defer s.access.Unlock()
+
+	c, ok := s.count[prbid]
+	if !ok {
+		c = &rcodeCount{}
+		s.count[prbid] = c
+	}
+	c.add(msg)
+}
+
+// addPoints adds a <name>Rcodes point and, if withNsec is set,
+// a <name>Nsec point for all probe metadata seen. Probes without
+// metadata yet are left out until it is known.
+func (s *probeCounts) addPoints(bp *Batch, name string, withNsec bool, now time.Time) {
+	s.access.Lock()
+	group := make(map[probeKey]*rcodeCount)
+	for prbid, c := range s.count {
+		key, ok := probeTags(prbid)
+		if !ok {
+			continue
+		}
+		if _, ok := group[key]; !ok {
+			group[key] = &rcodeCount{}
+		}
+		group[key].merge(c)
+	}
+	s.access.Unlock()
+
+	for key, c := range group {
+		addPoint(bp, name+"Rcodes", key.tags(), c.rcodeFields(), now)
+		if withNsec {
+			addPoint(bp, name+"Nsec", key.tags(), c.nsecFields(), now)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// distribution collects the values of one update interval
+type distribution struct {
+	access sync.Mutex
+	values []float64
+	bounds []float64
+}
+
+func newDistribution() *distribution {
+	return &distribution{values: make([]float64, 0)}
+}
+
+// newHistogram creates a distribution that also reports the number
+// of values less or equal to each bound
+func newHistogram(bounds []float64) *distribution {
+	return &distribution{values: make([]float64, 0), bounds: bounds}
+}
+
+// add records one value
+func (d *distribution) add(v float64) {
+	d.access.Lock()
+	defer d.access.Unlock()
+	d.values = append(d.values, v)
+}
+
+// drain returns the values and starts a new interval
+func (d *distribution) drain() []float64 {
+	d.access.Lock()
+	defer d.access.Unlock()
+	values := d.values
+	d.values = make([]float64, 0)
+	return values
+}
+
+// fields returns count, min, max, mean and quantiles of the values
+// and starts a new interval. Field names start with prefix.
+func (d *distribution) fields(prefix string) map[string]interface{} {
+	values := d.drain()
+
+	fields := map[string]interface{}{
+		prefix + "count": len(values),
+	}
+	sort.Float64s(values)
+
+	// histogram buckets
+	i := 0
+	for _, bound := range d.bounds {
+		for i < len(values) && values[i] <= bound {
+			i++
+		}
+		fields[prefix+"le_"+strconv.FormatFloat(bound, 'f', -1, 64)] = i
+	}
+
+	if len(values) == 0 {
+		return fields
+	}
+
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	fields[prefix+"min"] = values[0]
+	fields[prefix+"max"] = values[len(values)-1]
+	fields[prefix+"mean"] = sum / float64(len(values))
+	fields[prefix+"p50"] = quantile(values, 0.5)
+	fields[prefix+"p90"] = quantile(values, 0.9)
+	fields[prefix+"p99"] = quantile(values, 0.99)
+	return fields
+}
+
+// quantile returns the q quantile of sorted values (nearest rank)
+func quantile(sorted []float64, q float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(math.Ceil(q*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
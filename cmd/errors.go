@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	PARSEERROR = "parse"
+	ABUFERROR  = "abuf"
+)
+
+var statsErrors = make(map[string]map[string]int)
+var accessErrors = sync.Mutex{}
+
+// addError counts an error while handling results
+func addError(role string, kind string) {
+	accessErrors.Lock()
+	defer accessErrors.Unlock()
+
+	if _, ok := statsErrors[role]; !ok {
+		statsErrors[role] = make(map[string]int)
+	}
+	statsErrors[role][kind]++
+}
+
+// addErrorPoints adds the error counts of the role
+func addErrorPoints(bp *Batch, role string, now time.Time) {
+	accessErrors.Lock()
+	defer accessErrors.Unlock()
+
+	// values
+	fields := map[string]interface{}{
+		PARSEERROR: statsErrors[role][PARSEERROR],
+		ABUFERROR:  statsErrors[role][ABUFERROR],
+	}
+	addPoint(bp, "errors", map[string]string{"role": role}, fields, now)
+}
@@ -0,0 +1,209 @@
+/*
+Copyright © 2020 Ulrich Wisser <ulrich@wisser.se>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"encoding/csv"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/DNS-OARC/ripeatlas/measurement"
+	"github.com/DNS-OARC/ripeatlas/measurement/dns"
+	mdns "github.com/miekg/dns"
+	"github.com/parquet-go/parquet-go"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// exportRow is one response in the export, the NSEC3 parameters
+// are null for other denials
+type exportRow struct {
+	MsmID           int       `parquet:"msm_id"`
+	Role            string    `parquet:"role"`
+	PrbID           int       `parquet:"prb_id"`
+	Time            time.Time `parquet:"time"`
+	Dst             string    `parquet:"dst"`
+	Rcode           string    `parquet:"rcode"`
+	Denial          string    `parquet:"denial"`
+	NSEC3Hash       *int      `parquet:"nsec3_hash,optional"`
+	NSEC3Iterations *int      `parquet:"nsec3_iterations,optional"`
+	NSEC3Salt       *string   `parquet:"nsec3_salt,optional"`
+	EDE             string    `parquet:"ede"`
+	Rt              float64   `parquet:"rt"`
+	Flags           string    `parquet:"flags"`
+}
+
+var exportColumns = []string{"msm_id", "role", "prb_id", "time", "dst", "rcode", "denial",
+	"nsec3_hash", "nsec3_iterations", "nsec3_salt", "ede", "rt", "flags"}
+
+// exportCmd represents the export command
+var exportCmd = &cobra.Command{
+	Use:   "export FILE...",
+	Short: "export recorded results as csv or parquet",
+	Long: `export recorded results as csv or parquet
+
+Files are results recorded with --record or downloaded from Ripe Atlas.
+Roles are taken from the campaign manifest.`,
+	Args: cobra.MinimumNArgs(1),
+	Run:  runExport,
+}
+
+func init() {
+	rootCmd.AddCommand(exportCmd)
+	exportCmd.Flags().String("format", "csv", "output format (csv or parquet)")
+	exportCmd.Flags().StringP("output", "o", "-", "output file (-=stdout)")
+}
+
+func runExport(cmd *cobra.Command, args []string) {
+	roles := make(map[int]string)
+	if len(viper.GetString("manifest")) > 0 {
+		m, err := readManifest(viper.GetString("manifest"))
+		if err != nil {
+			log.Fatal("Could not read manifest. ", err)
+		}
+		roles = m.roles()
+	}
+
+	output, _ := cmd.Flags().GetString("output")
+	format, _ := cmd.Flags().GetString("format")
+
+	var out io.Writer = os.Stdout
+	if output != "-" {
+		f, err := os.Create(output)
+		if err != nil {
+			log.Fatal("Could not create output file. ", err)
+		}
+		defer f.Close()
+		out = f
+	}
+
+	var write func(row *exportRow) error
+	var done func() error
+	switch format {
+	case "csv":
+		w := csv.NewWriter(out)
+		w.Write(exportColumns)
+		write = func(row *exportRow) error { return w.Write(row.csv()) }
+		done = func() error {
+			w.Flush()
+			return w.Error()
+		}
+	case "parquet":
+		w := parquet.NewGenericWriter[exportRow](out)
+		write = func(row *exportRow) error {
+			_, err := w.Write([]exportRow{*row})
+			return err
+		}
+		done = w.Close
+	default:
+		log.Fatalf("Unknown format %s", format)
+	}
+
+	rows, skipped := 0, 0
+	for _, filename := range args {
+		err := readResults(filename, func(msm *measurement.Result) {
+			if msm.ParseError != nil || msm.Type() != "dns" {
+				skipped++
+				return
+			}
+			role, ok := roles[msm.MsmId()]
+			if !ok {
+				role = "unknown"
+			}
+			eachResult(msm, func(msm *measurement.Result, dst string, result *dns.Result) {
+				row, err := newExportRow(role, msm, dst, result)
+				if err != nil {
+					skipped++
+					return
+				}
+				if err = write(row); err != nil {
+					log.Fatal("Could not write row. ", err)
+				}
+				rows++
+			})
+		})
+		if err != nil {
+			log.Fatalf("Could not read %s: %s", filename, err)
+		}
+	}
+	if err := done(); err != nil {
+		log.Fatal("Could not write output. ", err)
+	}
+
+	if verbose > 0 {
+		log.Printf("Exported %d responses, skipped %d", rows, skipped)
+	}
+}
+
+// newExportRow decodes the abuf of a result
+func newExportRow(role string, msm *measurement.Result, dst string, result *dns.Result) (*exportRow, error) {
+	msg, err := result.UnpackAbuf()
+	if err != nil {
+		return nil, err
+	}
+	row := &exportRow{
+		MsmID:  msm.MsmId(),
+		Role:   role,
+		PrbID:  msm.PrbId(),
+		Time:   time.Unix(int64(msm.Timestamp()), 0).UTC(),
+		Dst:    dst,
+		Rcode:  mdns.RcodeToString[msg.Rcode],
+		Denial: nsec(msg.Ns),
+		EDE:    ede(msg),
+		Rt:     result.Rt(),
+		Flags:  flags(msg),
+	}
+	if scheme := responseScheme(msg.Ns); scheme.Type == NSEC3 {
+		hash, iterations, salt := int(scheme.Hash), int(scheme.Iterations), scheme.Salt
+		row.NSEC3Hash = &hash
+		row.NSEC3Iterations = &iterations
+		row.NSEC3Salt = &salt
+	}
+	return row, nil
+}
+
+// csv returns the row as csv fields, NSEC3 parameters are empty for other denials
+func (r *exportRow) csv() []string {
+	hash, iterations, salt := "", "", ""
+	if r.NSEC3Hash != nil {
+		hash = strconv.Itoa(*r.NSEC3Hash)
+	}
+	if r.NSEC3Iterations != nil {
+		iterations = strconv.Itoa(*r.NSEC3Iterations)
+	}
+	if r.NSEC3Salt != nil {
+		salt = *r.NSEC3Salt
+	}
+	return []string{
+		strconv.Itoa(r.MsmID),
+		r.Role,
+		strconv.Itoa(r.PrbID),
+		r.Time.Format(time.RFC3339),
+		r.Dst,
+		r.Rcode,
+		r.Denial,
+		hash,
+		iterations,
+		salt,
+		r.EDE,
+		strconv.FormatFloat(r.Rt, 'f', -1, 64),
+		r.Flags,
+	}
+}
@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+func TestExportParquetNSEC3(t *testing.T) {
+	hash, iterations, salt := 1, 0, ""
+	rows := []exportRow{
+		{MsmID: 1, Role: "static", PrbID: 2, Time: time.Unix(10, 0).UTC(), Rcode: "NXDOMAIN", Denial: NSEC3,
+			NSEC3Hash: &hash, NSEC3Iterations: &iterations, NSEC3Salt: &salt},
+		{MsmID: 1, Role: "static", PrbID: 3, Time: time.Unix(20, 0).UTC(), Rcode: "NXDOMAIN", Denial: NSEC},
+	}
+
+	var buf bytes.Buffer
+	w := parquet.NewGenericWriter[exportRow](&buf)
+	if _, err := w.Write(rows); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r := parquet.NewGenericReader[exportRow](bytes.NewReader(buf.Bytes()))
+	got := make([]exportRow, 2)
+	if n, _ := r.Read(got); n != 2 {
+		t.Fatalf("read %d rows", n)
+	}
+	r.Close()
+
+	if got[0].NSEC3Hash == nil || *got[0].NSEC3Hash != 1 || got[0].NSEC3Iterations == nil || *got[0].NSEC3Iterations != 0 {
+		t.Errorf("NSEC3 parameters lost: %+v", got[0])
+	}
+	if got[0].NSEC3Salt == nil || *got[0].NSEC3Salt != "" {
+		t.Errorf("empty salt lost: %+v", got[0])
+	}
+	if got[1].NSEC3Hash != nil || got[1].NSEC3Iterations != nil || got[1].NSEC3Salt != nil {
+		t.Errorf("NSEC parameters not null: %+v", got[1])
+	}
+
+	for _, c := range []string{"nsec3_hash", "nsec3_iterations", "nsec3_salt"} {
+		col, ok := r.Schema().Lookup(c)
+		if !ok || !col.Node.Optional() {
+			t.Errorf("column %s not optional", c)
+		}
+	}
+}
+
+func TestExportCSVNSEC3(t *testing.T) {
+	hash, iterations, salt := 1, 0, "AB"
+	row := exportRow{Time: time.Unix(10, 0).UTC(), Denial: NSEC3, NSEC3Hash: &hash, NSEC3Iterations: &iterations, NSEC3Salt: &salt}
+	if got := strings.Join(row.csv()[7:10], ","); got != "1,0,AB" {
+		t.Errorf("got %s", got)
+	}
+	row = exportRow{Time: time.Unix(10, 0).UTC(), Denial: NSEC}
+	if got := strings.Join(row.csv()[7:10], ","); got != ",," {
+		t.Errorf("got %s", got)
+	}
+}
@@ -0,0 +1,213 @@
+/*
+Copyright © 2020 Ulrich Wisser <ulrich@wisser.se>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// fetchCmd represents the fetch command
+var fetchCmd = &cobra.Command{
+	Use:   "fetch [measurement id...]",
+	Short: "download results from the Ripe Atlas API",
+	Long: `download results from the Ripe Atlas API
+
+Results are downloaded in chunks of --chunk, one file per measurement and
+chunk. The results API has no paging, the chunks keep every request small
+instead. Chunks start at multiples of --chunk, so the first and last file
+may hold results from before start and after stop.
+
+Chunks that were downloaded before are skipped, so an interrupted download
+is resumed by running the same command again. A chunk that has not ended
+yet is stored as <id>-<from>-<to>.partial.jsonl and downloaded again on
+every run, the partial file is removed once the complete chunk is stored.
+Without measurement ids, all measurements of the campaign manifest are
+downloaded.`,
+	Run: runFetch,
+}
+
+func init() {
+	rootCmd.AddCommand(fetchCmd)
+	fetchCmd.Flags().String("start", "", "start of the time range (default is the manifest start)")
+	fetchCmd.Flags().String("stop", "", "end of the time range (default is the manifest stop or now)")
+	fetchCmd.Flags().String("dir", ".", "directory to store the results in")
+	fetchCmd.Flags().Duration("chunk", time.Hour, "length of the time range of one request")
+	fetchCmd.Flags().Int("retries", 3, "how often a failed request is retried")
+}
+
+func runFetch(cmd *cobra.Command, args []string) {
+	var manifest *Manifest
+	if len(viper.GetString("manifest")) > 0 {
+		m, err := readManifest(viper.GetString("manifest"))
+		if err != nil {
+			log.Fatal("Could not read manifest. ", err)
+		}
+		manifest = m
+	}
+
+	// measurement ids
+	measurements := make([]int, 0)
+	for _, m := range args {
+		v, err := strconv.Atoi(m)
+		if err != nil {
+			log.Fatal("Could not convert to int: ", m)
+		}
+		measurements = append(measurements, v)
+	}
+	if len(measurements) == 0 && manifest != nil {
+		measurements = manifest.ids()
+	}
+	if len(measurements) == 0 {
+		log.Fatal("At least one measurement id or a manifest must be given")
+	}
+
+	// time range
+	var start, stop time.Time
+	if manifest != nil {
+		start, stop = manifest.Start, manifest.Stop
+	}
+	if s, _ := cmd.Flags().GetString("start"); len(s) > 0 {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			log.Fatal("Could not parse start time. ", err)
+		}
+		start = t
+	}
+	if s, _ := cmd.Flags().GetString("stop"); len(s) > 0 {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			log.Fatal("Could not parse stop time. ", err)
+		}
+		stop = t
+	}
+	if start.IsZero() {
+		log.Fatal("Start time must be given")
+	}
+	if stop.IsZero() || stop.After(time.Now()) {
+		stop = time.Now()
+	}
+	chunk, _ := cmd.Flags().GetDuration("chunk")
+	if chunk <= 0 {
+		log.Fatal("Chunk must be positive")
+	}
+
+	dir, _ := cmd.Flags().GetString("dir")
+	retries, _ := cmd.Flags().GetInt("retries")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Fatal("Could not create directory. ", err)
+	}
+
+	for _, id := range measurements {
+		for from := start.Truncate(chunk); from.Before(stop); from = from.Add(chunk) {
+			fetchChunk(dir, retries, id, from, from.Add(chunk))
+		}
+	}
+}
+
+// fetchChunk downloads the results of one measurement and chunk unless the
+// complete chunk was downloaded before. Chunks that have not ended yet are
+// downloaded up to now into the partial file of the chunk.
+func fetchChunk(dir string, retries int, id int, from, to time.Time) {
+	base := filepath.Join(dir, fmt.Sprintf("%d-%d-%d", id, from.Unix(), to.Unix()))
+	filename := base + ".jsonl"
+	partial := base + ".partial.jsonl"
+	if _, err := os.Stat(filename); err == nil {
+		if verbose > 1 {
+			log.Printf("Skipping %s, already downloaded", filename)
+		}
+		return
+	}
+
+	var err error
+	for try := 0; try <= retries; try++ {
+		if try > 0 {
+			time.Sleep(time.Duration(try) * 10 * time.Second)
+		}
+		now := time.Now().Truncate(time.Second)
+		if now.Before(to) {
+			err = downloadResults(id, from, now, partial)
+		} else if err = downloadResults(id, from, to, filename); err == nil {
+			os.Remove(partial)
+		}
+		if err == nil {
+			if verbose > 0 {
+				log.Printf("Downloaded measurement %d from %s to %s", id, from.Format(time.RFC3339), to.Format(time.RFC3339))
+			}
+			return
+		}
+		log.Printf("Could not download measurement %d from %s: %s", id, from.Format(time.RFC3339), err)
+	}
+	log.Fatalf("Giving up on measurement %d, run again to resume", id)
+}
+
+// downloadResults writes the results as JSON lines, the file is
+// renamed into place once it is complete
+func downloadResults(id int, from, to time.Time, filename string) error {
+	u, _ := url.Parse(fmt.Sprintf("https://atlas.ripe.net/api/v2/measurements/%d/results/", id))
+	q := u.Query()
+	q.Set("start", strconv.FormatInt(from.Unix(), 10))
+	// stop is inclusive
+	q.Set("stop", strconv.FormatInt(to.Unix()-1, 10))
+	q.Set("format", "txt")
+	if len(viper.GetString("APIKEY")) > 0 {
+		q.Set("key", viper.GetString("APIKEY"))
+	}
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "nsecmonitor/0.0")
+
+	client := &http.Client{Timeout: 10 * time.Minute}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("API call returned status %s", resp.Status)
+	}
+
+	f, err := os.Create(filename + ".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(f, resp.Body); err != nil {
+		f.Close()
+		os.Remove(filename + ".tmp")
+		return err
+	}
+	if err = f.Close(); err != nil {
+		os.Remove(filename + ".tmp")
+		return err
+	}
+	return os.Rename(filename+".tmp", filename)
+}
@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	mdns "github.com/miekg/dns"
+)
+
+// adKey identifies a combination of probe, CD bit, denial type and AD bit
+type adKey struct {
+	PrbID  int
+	CD     bool
+	Denial string
+	AD     bool
+}
+
+// adStats counts responses by the header bits the resolver returned
+type adStats struct {
+	access sync.Mutex
+	count  map[adKey]int
+}
+
+func newADStats() *adStats {
+	return &adStats{count: make(map[adKey]int)}
+}
+
+// add counts one response of the probe
+func (s *adStats) add(prbid int, msg *mdns.Msg) {
+	key := adKey{PrbID: prbid, CD: msg.CheckingDisabled, Denial: nsec(msg.Ns), AD: msg.AuthenticatedData}
+
+	s.access.Lock()
+	defer s.access.Unlock()
+	s.count[key]++
+}
+
+// addPoints adds one point per probe metadata and CD bit value to the batch.
+// Fields are named <denial type>_ad and <denial type>_noad.
+func (s *adStats) addPoints(bp *Batch, name string, now time.Time) {
+	// group the probes by metadata
+	s.access.Lock()
+	group := make(map[probeKey]map[adKey]int)
+	for key, n := range s.count {
+		probe, ok := probeTags(key.PrbID)
+		if !ok {
+			// counted once the metadata is known
+			continue
+		}
+		if _, ok := group[probe]; !ok {
+			group[probe] = make(map[adKey]int)
+		}
+		key.PrbID = 0
+		group[probe][key] += n
+	}
+	s.access.Unlock()
+
+	for probe, count := range group {
+		for _, cd := range []bool{false, true} {
+			fields := map[string]interface{}{}
+			for _, denial := range []string{NSEC, NSEC3, NONSEC} {
+				prefix := strings.ToLower(denial)
+				fields[prefix+"_ad"] = count[adKey{CD: cd, Denial: denial, AD: true}]
+				fields[prefix+"_noad"] = count[adKey{CD: cd, Denial: denial, AD: false}]
+			}
+			tags := probe.tags()
+			tags["cd"] = strconv.FormatBool(cd)
+			addPoint(bp, name, tags, fields, now)
+		}
+	}
+}
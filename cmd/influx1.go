@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+
+	_ "github.com/influxdata/influxdb1-client" // this is important because of the bug in go mod
+	client "github.com/influxdata/influxdb1-client/v2"
+
+	"github.com/spf13/viper"
+)
+
+// influx1Sink writes to InfluxDB 1.x
+type influx1Sink struct {
+	client   client.Client
+	database string
+}
+
+func init() {
+	sinkTypes["influx1"] = newInflux1Sink
+}
+
+// newInflux1Sink creates a sink from server, database, user and password
+func newInflux1Sink(name string, conf *viper.Viper) (Sink, error) {
+	if len(conf.GetString("server")) == 0 || len(conf.GetString("database")) == 0 {
+		return nil, fmt.Errorf("server and database must be given")
+	}
+
+	// influxdb client config
+	c := client.HTTPConfig{
+		Addr: conf.GetString("server"),
+	}
+	if len(conf.GetString("user")) > 0 {
+		c.Username = conf.GetString("user")
+		c.Password = conf.GetString("password")
+	}
+
+	// get access to Influx
+	influx, err := client.NewHTTPClient(c)
+	if err != nil {
+		return nil, err
+	}
+	return &influx1Sink{client: influx, database: conf.GetString("database")}, nil
+}
+
+func (s *influx1Sink) Write(points []*Point) error {
+	bp, err := client.NewBatchPoints(client.BatchPointsConfig{
+		Database:  s.database,
+		Precision: "s",
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, p := range points {
+		pt, err := client.NewPoint(p.Name, p.Tags, p.Fields, p.Time)
+		if err != nil {
+			log.Printf("Could not create new point %s: %s", p.Name, err)
+			continue
+		}
+		bp.AddPoint(pt)
+	}
+	return s.client.Write(bp)
+}
+
+func (s *influx1Sink) Close() error {
+	return s.client.Close()
+}
@@ -0,0 +1,193 @@
+package cmd
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// influx2Sink writes line protocol to the /api/v2/write endpoint
+// of InfluxDB 2.x and 3.x
+type influx2Sink struct {
+	url       string
+	token     string
+	gzip      bool
+	precision time.Duration
+	client    *http.Client
+}
+
+var precisions = map[string]time.Duration{
+	"ns": time.Nanosecond,
+	"us": time.Microsecond,
+	"ms": time.Millisecond,
+	"s":  time.Second,
+}
+
+func init() {
+	sinkTypes["influx2"] = newInflux2Sink
+}
+
+// newInflux2Sink creates a sink from url, org, bucket, token, gzip,
+// precision and timeout
+func newInflux2Sink(name string, conf *viper.Viper) (Sink, error) {
+	if len(conf.GetString("url")) == 0 || len(conf.GetString("bucket")) == 0 {
+		return nil, fmt.Errorf("url and bucket must be given")
+	}
+
+	precision := conf.GetString("precision")
+	if len(precision) == 0 {
+		precision = "s"
+	}
+	p, ok := precisions[precision]
+	if !ok {
+		return nil, fmt.Errorf("unknown precision %s", precision)
+	}
+
+	u, err := url.Parse(strings.TrimSuffix(conf.GetString("url"), "/") + "/api/v2/write")
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	if len(conf.GetString("org")) > 0 {
+		q.Set("org", conf.GetString("org"))
+	}
+	q.Set("bucket", conf.GetString("bucket"))
+	q.Set("precision", precision)
+	u.RawQuery = q.Encode()
+
+	timeout := conf.GetDuration("timeout")
+	if timeout <= 0 {
+		timeout = 20 * time.Second
+	}
+
+	return &influx2Sink{
+		url:       u.String(),
+		token:     conf.GetString("token"),
+		gzip:      conf.GetBool("gzip"),
+		precision: p,
+		client:    &http.Client{Timeout: timeout},
+	}, nil
+}
+
+func (s *influx2Sink) Write(points []*Point) error {
+	var body bytes.Buffer
+	for _, p := range points {
+		line := lineProtocol(p, s.precision)
+		if len(line) > 0 {
+			body.WriteString(line)
+			body.WriteByte('\n')
+		}
+	}
+	if body.Len() == 0 {
+		return nil
+	}
+
+	payload := body.Bytes()
+	if s.gzip {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		if _, err := gz.Write(payload); err != nil {
+			return err
+		}
+		if err := gz.Close(); err != nil {
+			return err
+		}
+		payload = buf.Bytes()
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "nsecmonitor/0.0")
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if len(s.token) > 0 {
+		req.Header.Set("Authorization", "Token "+s.token)
+	}
+	if s.gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("write returned status %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+func (s *influx2Sink) Close() error {
+	return nil
+}
+
+// lineProtocol formats a point, an empty string is returned
+// if the point has no fields
+func lineProtocol(p *Point, precision time.Duration) string {
+	fields := make([]string, 0, len(p.Fields))
+	for k, v := range p.Fields {
+		var value string
+		switch f := v.(type) {
+		case int:
+			value = strconv.Itoa(f) + "i"
+		case int64:
+			value = strconv.FormatInt(f, 10) + "i"
+		case uint32:
+			value = strconv.FormatUint(uint64(f), 10) + "i"
+		case float64:
+			// NaN and Inf are not allowed
+			if math.IsNaN(f) || math.IsInf(f, 0) {
+				continue
+			}
+			value = strconv.FormatFloat(f, 'f', -1, 64)
+		case bool:
+			value = strconv.FormatBool(f)
+		case string:
+			value = `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(f) + `"`
+		default:
+			continue
+		}
+		fields = append(fields, escapeKey(k)+"="+value)
+	}
+	if len(fields) == 0 {
+		return ""
+	}
+	sort.Strings(fields)
+
+	keys := make([]string, 0, len(p.Tags))
+	for k := range p.Tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var line strings.Builder
+	line.WriteString(strings.NewReplacer(",", `\,`, " ", `\ `).Replace(p.Name))
+	for _, k := range keys {
+		// empty tag values are not allowed
+		if len(p.Tags[k]) == 0 {
+			continue
+		}
+		line.WriteString("," + escapeKey(k) + "=" + escapeKey(p.Tags[k]))
+	}
+	line.WriteString(" " + strings.Join(fields, ","))
+	line.WriteString(" " + strconv.FormatInt(p.Time.UnixNano()/int64(precision), 10))
+	return line.String()
+}
+
+// escapeKey escapes tag keys, tag values and field keys
+func escapeKey(s string) string {
+	return strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `).Replace(s)
+}
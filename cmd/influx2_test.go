@@ -0,0 +1,159 @@
+package cmd
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func TestLineProtocol(t *testing.T) {
+	ts := time.Unix(1700000000, 0)
+	tests := []struct {
+		name string
+		p    *Point
+		want string
+	}{
+		{
+			"int and float",
+			&Point{Name: "staticRcodes", Tags: map[string]string{"role": "static"}, Fields: map[string]interface{}{"NOERROR": 10, "total": int64(12), "share": 0.5, "whole": 2.0}, Time: ts},
+			"staticRcodes,role=static NOERROR=10i,share=0.5,total=12i,whole=2 1700000000",
+		},
+		{
+			"escaping",
+			&Point{Name: "my measurement,x", Tags: map[string]string{"a b": "c,d", "e=f": "g=h"}, Fields: map[string]interface{}{"f g": 1}, Time: ts},
+			`my\ measurement\,x,a\ b=c\,d,e\=f=g\=h f\ g=1i 1700000000`,
+		},
+		{
+			"strings and bools",
+			&Point{Name: "m", Fields: map[string]interface{}{"s": `say "hi" \o/`, "b": true, "u": uint32(7)}, Time: ts},
+			`m b=true,s="say \"hi\" \\o/",u=7i 1700000000`,
+		},
+		{
+			"empty tags are dropped",
+			&Point{Name: "m", Tags: map[string]string{"a": "", "b": "x"}, Fields: map[string]interface{}{"f": 1}, Time: ts},
+			"m,b=x f=1i 1700000000",
+		},
+		{
+			"non-finite floats are dropped",
+			&Point{Name: "m", Fields: map[string]interface{}{"nan": math.NaN(), "inf": math.Inf(1), "f": 1.5}, Time: ts},
+			"m f=1.5 1700000000",
+		},
+		{
+			"small and large floats",
+			&Point{Name: "m", Fields: map[string]interface{}{"a": 0.000001, "b": 1e21}, Time: ts},
+			"m a=0.000001,b=1000000000000000000000 1700000000",
+		},
+		{
+			"no fields",
+			&Point{Name: "m", Fields: map[string]interface{}{"nan": math.NaN(), "x": []int{1}}, Time: ts},
+			"",
+		},
+	}
+	for _, tt := range tests {
+		if got := lineProtocol(tt.p, time.Second); got != tt.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", tt.name, got, tt.want)
+		}
+	}
+
+	p := &Point{Name: "m", Fields: map[string]interface{}{"f": 1}, Time: time.Unix(1, 500000000)}
+	if got := lineProtocol(p, time.Millisecond); got != "m f=1i 1500" {
+		t.Errorf("precision ms: got %s", got)
+	}
+}
+
+func TestInflux2Write(t *testing.T) {
+	var query, auth, encoding, body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v2/write" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		query = r.URL.RawQuery
+		auth = r.Header.Get("Authorization")
+		encoding = r.Header.Get("Content-Encoding")
+		reader := r.Body
+		if encoding == "gzip" {
+			gz, err := gzip.NewReader(r.Body)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			reader = gz
+		}
+		b, _ := ioutil.ReadAll(reader)
+		body = string(b)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	conf := viper.New()
+	conf.Set("url", srv.URL+"/")
+	conf.Set("org", "dns")
+	conf.Set("bucket", "nsec")
+	conf.Set("token", "secret")
+	conf.Set("gzip", true)
+	s, err := newInflux2Sink("test", conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	points := []*Point{
+		{Name: "a", Tags: map[string]string{"t": "x y"}, Fields: map[string]interface{}{"n": 1}, Time: time.Unix(10, 0)},
+		{Name: "b", Fields: map[string]interface{}{"f": 0.25}, Time: time.Unix(20, 0)},
+		{Name: "c", Fields: map[string]interface{}{}, Time: time.Unix(30, 0)},
+	}
+	if err := s.Write(points); err != nil {
+		t.Fatal(err)
+	}
+	if query != "bucket=nsec&org=dns&precision=s" {
+		t.Errorf("got query %s", query)
+	}
+	if auth != "Token secret" {
+		t.Errorf("got authorization %s", auth)
+	}
+	if encoding != "gzip" {
+		t.Errorf("got encoding %s", encoding)
+	}
+	if body != "a,t=x\\ y n=1i 10\nb f=0.25 20\n" {
+		t.Errorf("got body %q", body)
+	}
+}
+
+func TestInflux2WriteError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"code":"invalid","message":"field type conflict"}`))
+	}))
+	defer srv.Close()
+
+	conf := viper.New()
+	conf.Set("url", srv.URL)
+	conf.Set("bucket", "nsec")
+	s, err := newInflux2Sink("test", conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Write([]*Point{{Name: "a", Fields: map[string]interface{}{"n": 1}, Time: time.Unix(10, 0)}})
+	if err == nil {
+		t.Fatal("expected error for status 400")
+	}
+}
+
+func TestInflux2Conf(t *testing.T) {
+	conf := viper.New()
+	if _, err := newInflux2Sink("test", conf); err == nil {
+		t.Error("expected error without url and bucket")
+	}
+	conf.Set("url", "http://localhost:8086")
+	conf.Set("bucket", "nsec")
+	conf.Set("precision", "h")
+	if _, err := newInflux2Sink("test", conf); err == nil {
+		t.Error("expected error for unknown precision")
+	}
+}
@@ -24,16 +24,11 @@ import (
 	"github.com/DNS-OARC/ripeatlas/measurement"
 	"github.com/DNS-OARC/ripeatlas/measurement/dns"
 
-	_ "github.com/influxdata/influxdb1-client" // this is important because of the bug in go mod
-	client "github.com/influxdata/influxdb1-client/v2"
-
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
-
-	mdns "github.com/miekg/dns"
 )
 
-var statsInvalid [32]int
+var statsInvalid = newProbeCounts()
 
 // invalidCmd represents the invalid command
 var invalidCmd = &cobra.Command{
@@ -45,6 +40,8 @@ var invalidCmd = &cobra.Command{
 
 func init() {
 	rootCmd.AddCommand(invalidCmd)
+	invalidCmd.Flags().Int("validationwindow", 3, "number of responses used to classify the resolver of a probe")
+	invalidCmd.Flags().Bool("probetable", false, "write validation state of every probe")
 
 	// Use flags for viper values
 	viper.BindPFlags(invalidCmd.Flags())
@@ -81,12 +78,7 @@ func runInvalid(cmd *cobra.Command, args []string) {
 }
 
 func checkInvalidConf() {
-	if len(viper.GetString("influxserver")) == 0 {
-		log.Println("Influx server must be given")
-	}
-	if len(viper.GetString("influxdb")) == 0 {
-		log.Println("Influx database must be given")
-	}
+	checkSinkConf()
 }
 
 func rcvInvalid(ch <-chan *measurement.Result) {
@@ -95,6 +87,7 @@ func rcvInvalid(ch <-chan *measurement.Result) {
 		// if parsing fails
 		if msm.ParseError != nil {
 			log.Println(msm.ParseError.Error())
+			addError("invalid", PARSEERROR)
 			continue
 		}
 
@@ -109,86 +102,50 @@ func rcvInvalid(ch <-chan *measurement.Result) {
 			log.Printf("%d %s", msm.MsmId(), msm.Type())
 		}
 
-		// handle single result
-		if msm.DnsResult() != nil {
-			handleInvalid(msm.DnsResult())
-		}
-		for _, s := range msm.DnsResultsets() {
-			if s.Result() != nil {
-				handleInvalid(s.Result())
-			}
-		}
+		// handle the result once its window is closed
+		addResult(msm, handleInvalid)
 	}
 
 }
 
-func handleInvalid(result *dns.Result) {
+func handleInvalid(msm *measurement.Result, dst string, result *dns.Result) {
 
 	msg, err := result.UnpackAbuf()
 	if err != nil {
 		log.Println("Could not unpack Abuf ", err)
+		addError("invalid", ABUFERROR)
 		return
 	}
-	statsInvalid[msg.Rcode]++
+	storeResponse("invalid", msm, dst, result, msg)
+	statsInvalid.add(msm.PrbId(), msg)
+	addResolver("invalid", msm.PrbId(), msm.Af(), dst, msg)
+	addRtt("invalid", msm.PrbId(), msm.Af(), dst, result.Rt())
+	addSize("invalid", msm.MsmId(), result.Size(), msg)
+
+	// with the CD bit set every resolver returns data
+	if !msg.CheckingDisabled {
+		updateValidation(msm.PrbId(), msm.Af(), classifyValidation(msg), time.Unix(int64(msm.Timestamp()), 0))
+	}
 }
 
 func saveInvalidStats() {
-	// influxdb client config
-	conf := client.HTTPConfig{
-		Addr: viper.GetString("influxserver"),
-	}
-	if len(viper.GetString("influxuser")) > 0 {
-		conf.Username = viper.GetString("influxuser")
-		conf.Password = viper.GetString("influxpasswd")
-	}
+	saveStats(func(bp *Batch, now time.Time) {
+		// rcodes
+		statsInvalid.addPoints(bp, "invalid", false, now)
 
-	// get access to Influx
-	influx, err := client.NewHTTPClient(conf)
-	if err != nil {
-		log.Fatalf("Error creating InfluxDB Client: %s", err.Error())
-	}
+		// validation state of probes
+		addValidationPoints(bp, now)
 
-	ticker := time.NewTicker(UPDATEINTERVAL)
-	for {
-		now := <-ticker.C
+		// per resolver
+		addResolverPoints(bp, "invalid", now)
 
-		bp, err := client.NewBatchPoints(client.BatchPointsConfig{
-			Database:  viper.GetString("influxdb"),
-			Precision: "s",
-		})
-		if err != nil {
-			log.Fatalf("Could not create new batch points: %s", err.Error())
-		}
+		// response times
+		addRttPoints(bp, "invalid", now)
 
-		// tags
-		tags := map[string]string{}
+		// response sizes and edns
+		addSizePoints(bp, "invalid", now)
 
-		// values
-		fields := map[string]interface{}{}
-		for _, rcode := range RCODES {
-			fields[mdns.RcodeToString[rcode]] = statsInvalid[rcode]
-		}
-
-		// create new point
-		pt, err := client.NewPoint("invalidRcodes", tags, fields, now)
-		if err != nil {
-			log.Fatal("Could not create new point. ", err)
-		}
-		if verbose > 2 {
-			log.Printf("Tags:   %v\n", tags)
-			log.Printf("Fields: %v\n", fields)
-		}
-
-		// add point to list
-		bp.AddPoint(pt)
-
-		// write to database
-		if verbose > 1 {
-			log.Println("Writing to influx")
-		}
-		err = influx.Write(bp)
-		if err != nil {
-			log.Printf("Error writing to InfluxDB: %s", err.Error())
-		}
-	}
+		// errors
+		addErrorPoints(bp, "invalid", now)
+	})
 }
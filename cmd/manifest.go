@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Manifest describes a measurement campaign, it is written by the
+// measure command and read by fetch, export and report
+type Manifest struct {
+	Start        time.Time             `json:"start"`
+	Stop         time.Time             `json:"stop"`
+	Parameters   map[string]string     `json:"parameters"`
+	Measurements []ManifestMeasurement `json:"measurements"`
+}
+
+// ManifestMeasurement is one Ripe Atlas measurement of the campaign
+type ManifestMeasurement struct {
+	ID            int    `json:"id"`
+	Role          string `json:"role"`
+	Description   string `json:"description"`
+	AF            int    `json:"af"`
+	Target        string `json:"target,omitempty"`
+	QueryArgument string `json:"query_argument"`
+	CD            bool   `json:"cd"`
+	PayloadSize   int    `json:"payload_size,omitempty"`
+	Interval      int    `json:"interval"`
+}
+
+// readManifest reads a manifest file
+func readManifest(filename string) (*Manifest, error) {
+	body, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	m := &Manifest{}
+	if err = json.Unmarshal(body, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// writeManifest writes a manifest file
+func writeManifest(filename string, m *Manifest) error {
+	body, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, append(body, '\n'), 0644)
+}
+
+// addMeasurements adds the created measurements, Ripe Atlas returns
+// the ids in the order of the definitions
+func (m *Manifest) addMeasurements(defs []Definition, ids []int) {
+	for i, def := range defs {
+		if i >= len(ids) {
+			break
+		}
+		m.Measurements = append(m.Measurements, ManifestMeasurement{
+			ID:            ids[i],
+			Role:          definitionRole(def),
+			Description:   def.Description,
+			AF:            def.AF,
+			Target:        def.Target,
+			QueryArgument: def.QueryArgument,
+			CD:            def.SetCDBit,
+			PayloadSize:   def.UDPPayloadSize,
+			Interval:      def.Interval,
+		})
+	}
+}
+
+// roles maps measurement ids to roles
+func (m *Manifest) roles() map[int]string {
+	roles := make(map[int]string)
+	for _, msm := range m.Measurements {
+		roles[msm.ID] = msm.Role
+	}
+	return roles
+}
+
+// ids returns the sorted measurement ids
+func (m *Manifest) ids() []int {
+	ids := make([]int, 0, len(m.Measurements))
+	for _, msm := range m.Measurements {
+		ids = append(ids, msm.ID)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// definitionRole returns the role of a definition made by the measure command
+func definitionRole(def Definition) string {
+	switch {
+	case strings.HasPrefix(def.Description, "Invalid"):
+		return "invalid"
+	case strings.HasPrefix(def.Description, "Static"):
+		return "static"
+	case strings.HasPrefix(def.Description, "Random"):
+		return "random"
+	case strings.HasPrefix(def.Description, "Direct to Authoritative"):
+		return "auth"
+	}
+	return "unknown"
+}
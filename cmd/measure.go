@@ -25,6 +25,8 @@ import (
 	"log"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/spf13/cobra"
@@ -47,8 +49,11 @@ var measureCmd = &cobra.Command{
 		d, _ := cmd.Flags().GetDuration("duration")
 		stop := start.Add(d)
 
+		manifest := &Manifest{Start: start, Stop: stop, Parameters: manifestParameters()}
+
+		defs1 := sweepPayloadSizes(makeDefinitions())
 		req1 := &MeasurementRequest{
-			Definitions: makeDefinitions(),
+			Definitions: defs1,
 			BillTo:      viper.GetString("RIPEACCOUNT"),
 			IsOneoff:    false,
 			Probes:      probes,
@@ -58,9 +63,11 @@ var measureCmd = &cobra.Command{
 
 		resp1 := createMeasurement(req1)
 		log.Printf("INVALID/STATIC/RANDOM %v", resp1)
+		manifest.addMeasurements(defs1, resp1.Measurements)
 
+		defs2 := sweepPayloadSizes(makeAuth4Definitions())
 		req2 := &MeasurementRequest{
-			Definitions: makeAuth4Definitions(),
+			Definitions: defs2,
 			BillTo:      viper.GetString("RIPEACCOUNT"),
 			IsOneoff:    false,
 			Probes:      probesV4,
@@ -70,9 +77,11 @@ var measureCmd = &cobra.Command{
 
 		resp2 := createMeasurement(req2)
 		log.Printf("AUTHORITATIVE v4 %v", resp2)
+		manifest.addMeasurements(defs2, resp2.Measurements)
 
+		defs3 := sweepPayloadSizes(makeAuth6Definitions())
 		req3 := &MeasurementRequest{
-			Definitions: makeAuth6Definitions(),
+			Definitions: defs3,
 			BillTo:      viper.GetString("RIPEACCOUNT"),
 			IsOneoff:    false,
 			Probes:      probesV6,
@@ -82,6 +91,14 @@ var measureCmd = &cobra.Command{
 
 		resp3 := createMeasurement(req3)
 		log.Printf("AUTHORITATIVE  v6 %v", resp3)
+		manifest.addMeasurements(defs3, resp3.Measurements)
+
+		// campaign manifest for fetch, export and report
+		if len(viper.GetString("manifest")) > 0 {
+			if err := writeManifest(viper.GetString("manifest"), manifest); err != nil {
+				log.Fatal("Could not write manifest. ", err)
+			}
+		}
 	},
 }
 
@@ -93,6 +110,8 @@ func init() {
 	measureCmd.Flags().StringP("begin", "b", "", "time and date for the measurement to start (empty=now)")
 	measureCmd.Flags().StringSliceP("authoritative", "a", []string{}, "name of authoritative name servers")
 	measureCmd.Flags().DurationP("duration", "d", 4*time.Hour, "how long the measurement should be run")
+	measureCmd.Flags().Bool("cd", false, "add a variant with CD bit set for every resolver measurement")
+	measureCmd.Flags().IntSlice("payloadsizes", []int{}, "create every measurement once per EDNS udp payload size")
 
 	// Use flags for viper values
 	viper.BindPFlags(measureCmd.Flags())
@@ -148,11 +167,26 @@ func checkConf() {
 		log.Println("Start:         ", start)
 		log.Println("Duration:      ", d.String())
 		log.Println("Authoritative: ", viper.GetStringSlice("authoritative"))
+		log.Println("CD variant:    ", viper.GetBool("cd"))
+		log.Println("Payload sizes: ", viper.GetIntSlice("payloadsizes"))
 		log.Println("Ripe account:  ", viper.GetString("RIPEACCOUNT"))
 		log.Println("APIKEY:        ", viper.GetString("APIKEY"))
 	}
 }
 
+// manifestParameters returns the campaign parameters for the manifest
+func manifestParameters() map[string]string {
+	return map[string]string{
+		"invalid":       viper.GetString("invalid"),
+		"static":        viper.GetString("static"),
+		"random":        viper.GetString("random"),
+		"authoritative": strings.Join(viper.GetStringSlice("authoritative"), " "),
+		"cd":            strconv.FormatBool(viper.GetBool("cd")),
+		"payloadsizes":  fmt.Sprint(viper.GetIntSlice("payloadsizes")),
+		"duration":      viper.GetDuration("duration").String(),
+	}
+}
+
 func makeDefinitions() []Definition {
 	defs := make([]Definition, 0)
 
@@ -182,6 +216,17 @@ func makeDefinitions() []Definition {
 	def3.QueryArgument = "$r-$p-$t-" + random
 	defs = append(defs, def3)
 
+	// same queries with checking disabled
+	// shows if the resolver validates the denial of existence
+	if viper.GetBool("cd") {
+		for _, def := range defs {
+			defcd := def
+			defcd.SetCDBit = true
+			defcd.Description = def.Description + " (CD)"
+			defs = append(defs, defcd)
+		}
+	}
+
 	// done
 	return defs
 }
@@ -220,6 +265,28 @@ func makeAuth6Definitions() []Definition {
 	return defs
 }
 
+// sweepPayloadSizes repeats all definitions for every udp payload size
+// given in "payloadsizes"
+func sweepPayloadSizes(defs []Definition) []Definition {
+	sizes := viper.GetIntSlice("payloadsizes")
+	if len(sizes) == 0 {
+		return defs
+	}
+
+	sweep := make([]Definition, 0)
+	for _, size := range sizes {
+		for _, def := range defs {
+			defsize := def
+			defsize.UDPPayloadSize = size
+			defsize.Description = fmt.Sprintf("%s (%d bytes)", def.Description, size)
+			sweep = append(sweep, defsize)
+		}
+	}
+
+	// done
+	return sweep
+}
+
 // createMeasurement creates a measurement for all types
 func createMeasurement(d *MeasurementRequest) *MeasurementResponse {
 	apiurl, _ := url.Parse(fmt.Sprintf("https://atlas.ripe.net/api/v2/measurements/?key=%s", viper.GetString("APIKEY")))
@@ -0,0 +1,330 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Notifier sends an alert somewhere
+type Notifier interface {
+	Notify(a *Alert) error
+}
+
+// notifierConf is one entry of the "notifiers" section of the config file
+//
+//	notifiers:
+//	  - type: webhook
+//	    url: https://example.com/hook
+//	  - type: smtp
+//	    server: localhost:25
+//	    from: nsecmonitor@example.com
+//	    to: [noc@example.com]
+//	  - type: command
+//	    command: /usr/local/bin/page
+//
+// Template, subject and body are text/template strings with the alert as data.
+type notifierConf struct {
+	Type       string        `mapstructure:"type"`
+	URL        string        `mapstructure:"url"`
+	Server     string        `mapstructure:"server"`
+	Username   string        `mapstructure:"username"`
+	Password   string        `mapstructure:"password"`
+	From       string        `mapstructure:"from"`
+	To         []string      `mapstructure:"to"`
+	Subject    string        `mapstructure:"subject"`
+	Template   string        `mapstructure:"template"`
+	Command    string        `mapstructure:"command"`
+	Args       []string      `mapstructure:"args"`
+	Timeout    time.Duration `mapstructure:"timeout"`
+	Retries    int           `mapstructure:"retries"`
+	RetryWait  time.Duration `mapstructure:"retrywait"`
+	RateLimit  int           `mapstructure:"ratelimit"`
+	RatePeriod time.Duration `mapstructure:"rateperiod"`
+}
+
+// notifier wraps a Notifier with retries and rate limiting
+type notifier struct {
+	name   string
+	n      Notifier
+	conf   notifierConf
+	access sync.Mutex
+	sent   []time.Time
+}
+
+var notifiers []*notifier
+var notifyInit = sync.Once{}
+
+const (
+	defaultSubject  = "[nsecmonitor] {{.Name}} {{.State}}"
+	defaultTemplate = "{{.Name}} is {{.State}} since {{.Since}}\n\n{{.Expr}}\nvalue {{.Value}} threshold {{.Threshold}}\n"
+)
+
+// loadNotifiers creates the notifiers from the config file
+func loadNotifiers() {
+	confs := make([]notifierConf, 0)
+	if err := viper.UnmarshalKey("notifiers", &confs); err != nil {
+		log.Fatal("Could not read notifiers. ", err)
+	}
+	for i, conf := range confs {
+		var n Notifier
+		var err error
+		switch conf.Type {
+		case "webhook":
+			n, err = newWebhookNotifier(conf)
+		case "smtp":
+			n, err = newSMTPNotifier(conf)
+		case "command":
+			n, err = newCommandNotifier(conf)
+		default:
+			err = fmt.Errorf("unknown type %s", conf.Type)
+		}
+		if err != nil {
+			log.Fatalf("Notifier %d: %s", i, err)
+		}
+		if conf.RetryWait <= 0 {
+			conf.RetryWait = 10 * time.Second
+		}
+		if conf.RatePeriod <= 0 {
+			conf.RatePeriod = time.Hour
+		}
+		notifiers = append(notifiers, &notifier{name: fmt.Sprintf("%s#%d", conf.Type, i), n: n, conf: conf})
+	}
+	if verbose > 0 {
+		log.Printf("Loaded %d notifiers", len(notifiers))
+	}
+}
+
+// notify sends a copy of the alert to all notifiers in the background
+func notify(a *Alert) {
+	notifyInit.Do(loadNotifiers)
+
+	alert := *a
+	for _, n := range notifiers {
+		go n.send(&alert)
+	}
+}
+
+// send notifies unless the rate limit is reached and retries on errors
+func (n *notifier) send(a *Alert) {
+	if !n.allow(a.Since) {
+		log.Printf("Notifier %s rate limited, dropped %s %s", n.name, a.Name, a.State)
+		return
+	}
+
+	var err error
+	for try := 0; try <= n.conf.Retries; try++ {
+		if try > 0 {
+			time.Sleep(n.conf.RetryWait)
+		}
+		if err = n.n.Notify(a); err == nil {
+			return
+		}
+		log.Printf("Notifier %s failed: %s", n.name, err)
+	}
+	log.Printf("Notifier %s gave up on %s %s", n.name, a.Name, a.State)
+}
+
+// allow checks if less than "ratelimit" alerts were sent during the
+// last "rateperiod", a limit of 0 means no limit
+func (n *notifier) allow(now time.Time) bool {
+	if n.conf.RateLimit <= 0 {
+		return true
+	}
+
+	n.access.Lock()
+	defer n.access.Unlock()
+
+	sent := make([]time.Time, 0)
+	for _, t := range n.sent {
+		if now.Sub(t) < n.conf.RatePeriod {
+			sent = append(sent, t)
+		}
+	}
+	n.sent = sent
+	if len(n.sent) >= n.conf.RateLimit {
+		return false
+	}
+	n.sent = append(n.sent, now)
+	return true
+}
+
+// render executes a template with the alert as data
+func render(t *template.Template, a *Alert) (string, error) {
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, a); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// webhookNotifier posts the alert as JSON
+type webhookNotifier struct {
+	url      string
+	template *template.Template
+	client   *http.Client
+}
+
+func newWebhookNotifier(conf notifierConf) (*webhookNotifier, error) {
+	if len(conf.URL) == 0 {
+		return nil, fmt.Errorf("webhook url must be given")
+	}
+	n := &webhookNotifier{url: conf.URL, client: &http.Client{Timeout: 20 * time.Second}}
+	if conf.Timeout > 0 {
+		n.client.Timeout = conf.Timeout
+	}
+	if len(conf.Template) > 0 {
+		t, err := template.New("webhook").Parse(conf.Template)
+		if err != nil {
+			return nil, err
+		}
+		n.template = t
+	}
+	return n, nil
+}
+
+func (n *webhookNotifier) Notify(a *Alert) error {
+	var body []byte
+	if n.template != nil {
+		s, err := render(n.template, a)
+		if err != nil {
+			return err
+		}
+		body = []byte(s)
+	} else {
+		b, err := json.Marshal(a)
+		if err != nil {
+			return err
+		}
+		body = b
+	}
+
+	req, err := http.NewRequest(http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "nsecmonitor/0.0")
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned status %s", resp.Status)
+	}
+	return nil
+}
+
+// smtpNotifier sends the alert as mail
+type smtpNotifier struct {
+	server   string
+	auth     smtp.Auth
+	from     string
+	to       []string
+	subject  *template.Template
+	template *template.Template
+}
+
+func newSMTPNotifier(conf notifierConf) (*smtpNotifier, error) {
+	if len(conf.Server) == 0 || len(conf.From) == 0 || len(conf.To) == 0 {
+		return nil, fmt.Errorf("smtp server, from and to must be given")
+	}
+	host, _, err := net.SplitHostPort(conf.Server)
+	if err != nil {
+		return nil, err
+	}
+	n := &smtpNotifier{server: conf.Server, from: conf.From, to: conf.To}
+	if len(conf.Username) > 0 {
+		n.auth = smtp.PlainAuth("", conf.Username, conf.Password, host)
+	}
+	if len(conf.Subject) == 0 {
+		conf.Subject = defaultSubject
+	}
+	if len(conf.Template) == 0 {
+		conf.Template = defaultTemplate
+	}
+	if n.subject, err = template.New("subject").Parse(conf.Subject); err != nil {
+		return nil, err
+	}
+	if n.template, err = template.New("body").Parse(conf.Template); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+func (n *smtpNotifier) Notify(a *Alert) error {
+	subject, err := render(n.subject, a)
+	if err != nil {
+		return err
+	}
+	body, err := render(n.template, a)
+	if err != nil {
+		return err
+	}
+
+	msg := "From: " + n.from + "\r\n" +
+		"To: " + strings.Join(n.to, ", ") + "\r\n" +
+		"Subject: " + strings.TrimSpace(subject) + "\r\n" +
+		"Date: " + time.Now().Format(time.RFC1123Z) + "\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" +
+		strings.ReplaceAll(body, "\n", "\r\n")
+	return smtp.SendMail(n.server, n.auth, n.from, n.to, []byte(msg))
+}
+
+// commandNotifier runs a local command with the alert in the environment
+type commandNotifier struct {
+	command string
+	args    []string
+	timeout time.Duration
+}
+
+func newCommandNotifier(conf notifierConf) (*commandNotifier, error) {
+	if len(conf.Command) == 0 {
+		return nil, fmt.Errorf("command must be given")
+	}
+	n := &commandNotifier{command: conf.Command, args: conf.Args, timeout: time.Minute}
+	if conf.Timeout > 0 {
+		n.timeout = conf.Timeout
+	}
+	return n, nil
+}
+
+func (n *commandNotifier) Notify(a *Alert) error {
+	cmd := exec.Command(n.command, n.args...)
+	cmd.Env = append(os.Environ(),
+		"NSECM_ALERT_NAME="+a.Name,
+		"NSECM_ALERT_STATE="+a.State,
+		"NSECM_ALERT_EXPR="+a.Expr,
+		fmt.Sprintf("NSECM_ALERT_VALUE=%g", a.Value),
+		fmt.Sprintf("NSECM_ALERT_THRESHOLD=%g", a.Threshold),
+		"NSECM_ALERT_SINCE="+a.Since.Format(time.RFC3339),
+	)
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(n.timeout):
+		cmd.Process.Kill()
+		return fmt.Errorf("command %s timed out", n.command)
+	}
+}
@@ -0,0 +1,301 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testAlert() *Alert {
+	return &Alert{
+		Name:      "servfail",
+		Expr:      "ratio(staticRcodes.SERVFAIL) > 5%",
+		State:     "firing",
+		Value:     0.1,
+		Threshold: 0.05,
+		Since:     time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	var hits int32
+	var got Alert
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&hits, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer srv.Close()
+
+	conf := notifierConf{Type: "webhook", URL: srv.URL, Retries: 3, RetryWait: time.Millisecond}
+	w, err := newWebhookNotifier(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := &notifier{name: "webhook#0", n: w, conf: conf}
+	n.send(testAlert())
+
+	if hits != 3 {
+		t.Errorf("got %d requests, want 3", hits)
+	}
+	if got.Name != "servfail" || got.State != "firing" {
+		t.Errorf("got alert %+v", got)
+	}
+}
+
+func TestWebhookGivesUp(t *testing.T) {
+	var hits int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	conf := notifierConf{Type: "webhook", URL: srv.URL, Retries: 2, RetryWait: time.Millisecond}
+	w, err := newWebhookNotifier(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Notify(testAlert()); err == nil {
+		t.Error("expected error for status 500")
+	}
+	atomic.StoreInt32(&hits, 0)
+
+	n := &notifier{name: "webhook#0", n: w, conf: conf}
+	n.send(testAlert())
+	if hits != 3 {
+		t.Errorf("got %d requests, want 3", hits)
+	}
+}
+
+func TestWebhookTemplate(t *testing.T) {
+	var body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b := new(strings.Builder)
+		bufio.NewReader(r.Body).WriteTo(b)
+		body = b.String()
+	}))
+	defer srv.Close()
+
+	w, err := newWebhookNotifier(notifierConf{URL: srv.URL, Template: `{"text":"{{.Name}} {{.State}}"}`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Notify(testAlert()); err != nil {
+		t.Fatal(err)
+	}
+	if body != `{"text":"servfail firing"}` {
+		t.Errorf("got body %q", body)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	n := &notifier{conf: notifierConf{RateLimit: 2, RatePeriod: time.Hour}}
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	if !n.allow(now) || !n.allow(now.Add(time.Minute)) {
+		t.Fatal("first two alerts must be allowed")
+	}
+	if n.allow(now.Add(2 * time.Minute)) {
+		t.Error("third alert within the period must be dropped")
+	}
+	if !n.allow(now.Add(time.Hour)) {
+		t.Error("alert after the period must be allowed")
+	}
+
+	var hits int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+	}))
+	defer srv.Close()
+	conf := notifierConf{URL: srv.URL, RateLimit: 1, RatePeriod: time.Hour}
+	w, _ := newWebhookNotifier(conf)
+	n = &notifier{name: "webhook#0", n: w, conf: conf}
+	n.send(testAlert())
+	n.send(testAlert())
+	if hits != 1 {
+		t.Errorf("got %d requests, want 1", hits)
+	}
+
+	// no limit
+	n = &notifier{conf: notifierConf{}}
+	for i := 0; i < 100; i++ {
+		if !n.allow(now) {
+			t.Fatal("alert dropped without rate limit")
+		}
+	}
+}
+
+// smtpServer accepts one connection per mail and records the data
+type smtpServer struct {
+	l     net.Listener
+	mu    sync.Mutex
+	rcpt  []string
+	data  []string
+	fails int
+}
+
+func newSMTPServer(t *testing.T) *smtpServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &smtpServer{l: l}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(c)
+		}
+	}()
+	return s
+}
+
+func (s *smtpServer) serve(c net.Conn) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+	reply := func(line string) { c.Write([]byte(line + "\r\n")) }
+
+	reply("220 localhost ESMTP test")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(cmd, "MAIL FROM"):
+			s.mu.Lock()
+			fail := s.fails > 0
+			if fail {
+				s.fails--
+			}
+			s.mu.Unlock()
+			if fail {
+				reply("451 try again later")
+				continue
+			}
+			reply("250 ok")
+		case strings.HasPrefix(cmd, "RCPT TO"):
+			s.mu.Lock()
+			s.rcpt = append(s.rcpt, strings.TrimSpace(line[8:]))
+			s.mu.Unlock()
+			reply("250 ok")
+		case cmd == "DATA":
+			reply("354 go ahead")
+			var data strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data.WriteString(l)
+			}
+			s.mu.Lock()
+			s.data = append(s.data, data.String())
+			s.mu.Unlock()
+			reply("250 ok")
+		case cmd == "RSET", cmd == "NOOP":
+			reply("250 ok")
+		case cmd == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+func TestSMTPNotifier(t *testing.T) {
+	s := newSMTPServer(t)
+	defer s.l.Close()
+	s.fails = 1
+
+	conf := notifierConf{
+		Type:      "smtp",
+		Server:    s.l.Addr().String(),
+		From:      "nsecmonitor@example.com",
+		To:        []string{"noc@example.com"},
+		Retries:   1,
+		RetryWait: time.Millisecond,
+	}
+	m, err := newSMTPNotifier(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := &notifier{name: "smtp#0", n: m, conf: conf}
+	n.send(testAlert())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.data) != 1 {
+		t.Fatalf("got %d mails, want 1", len(s.data))
+	}
+	if len(s.rcpt) != 1 || !strings.Contains(s.rcpt[0], "noc@example.com") {
+		t.Errorf("got recipients %v", s.rcpt)
+	}
+	if !strings.Contains(s.data[0], "Subject: [nsecmonitor] servfail firing\r\n") {
+		t.Errorf("subject missing in %q", s.data[0])
+	}
+	if !strings.Contains(s.data[0], "servfail is firing since") {
+		t.Errorf("body missing in %q", s.data[0])
+	}
+}
+
+func TestSMTPNotifierConf(t *testing.T) {
+	if _, err := newSMTPNotifier(notifierConf{Server: "localhost:25"}); err == nil {
+		t.Error("expected error without from and to")
+	}
+	if _, err := newSMTPNotifier(notifierConf{Server: "localhost", From: "a@example.com", To: []string{"b@example.com"}}); err == nil {
+		t.Error("expected error without port")
+	}
+}
+
+func TestCommandNotifier(t *testing.T) {
+	c, err := newCommandNotifier(notifierConf{Command: "sh", Args: []string{"-c", `test "$NSECM_ALERT_NAME" = servfail && test "$NSECM_ALERT_STATE" = firing`}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Notify(testAlert()); err != nil {
+		t.Errorf("command failed: %s", err)
+	}
+
+	c, _ = newCommandNotifier(notifierConf{Command: "false"})
+	if err := c.Notify(testAlert()); err == nil {
+		t.Error("expected error for failing command")
+	}
+
+	c, _ = newCommandNotifier(notifierConf{Command: "/nonexistent/notify"})
+	if err := c.Notify(testAlert()); err == nil {
+		t.Error("expected error for missing command")
+	}
+
+	c, _ = newCommandNotifier(notifierConf{Command: "sleep", Args: []string{"10"}, Timeout: 50 * time.Millisecond})
+	start := time.Now()
+	if err := c.Notify(testAlert()); err == nil {
+		t.Error("expected timeout")
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Error("command was not killed")
+	}
+
+	if _, err := newCommandNotifier(notifierConf{}); err == nil {
+		t.Error("expected error without command")
+	}
+}
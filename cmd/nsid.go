@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	mdns "github.com/miekg/dns"
+)
+
+// nsidKey identifies one instance of an authoritative server
+type nsidKey struct {
+	Server string
+	Af     int
+	Nsid   string
+}
+
+// nsidCount holds the statistics of one server instance
+type nsidCount struct {
+	Rcode  [32]int
+	Denial map[string]int
+	Rt     *distribution
+}
+
+var statsAuthNsid = make(map[nsidKey]*nsidCount)
+var accessAuthNsid = sync.Mutex{}
+
+// addNsid counts the response for the server instance that sent it
+func addNsid(server string, af int, msg *mdns.Msg, rt float64) {
+	key := nsidKey{Server: server, Af: af, Nsid: nsid(msg)}
+
+	accessAuthNsid.Lock()
+	defer accessAuthNsid.Unlock()
+
+	c, ok := statsAuthNsid[key]
+	if !ok {
+		c = &nsidCount{Denial: make(map[string]int), Rt: newDistribution()}
+		statsAuthNsid[key] = c
+	}
+	c.Rcode[msg.Rcode]++
+	c.Denial[nsec(msg.Ns)]++
+	c.Rt.add(rt)
+}
+
+// addNsidPoints adds one point per server instance
+func addNsidPoints(bp *Batch, now time.Time) {
+	accessAuthNsid.Lock()
+	defer accessAuthNsid.Unlock()
+
+	for key, c := range statsAuthNsid {
+		tags := map[string]string{
+			"server": key.Server,
+			"af":     strconv.Itoa(key.Af),
+			"nsid":   key.Nsid,
+		}
+
+		// values
+		fields := c.Rt.fields("rt_")
+		for _, rcode := range RCODES {
+			fields[mdns.RcodeToString[rcode]] = c.Rcode[rcode]
+		}
+		fields["nsec"] = c.Denial[NSEC]
+		fields["nsec3"] = c.Denial[NSEC3]
+		fields["nonsec"] = c.Denial[NONSEC]
+
+		addPoint(bp, "authNsid", tags, fields, now)
+	}
+}
@@ -0,0 +1,247 @@
+package cmd
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/spf13/viper"
+)
+
+// postgresMigrations are applied in order, the index + 1 is the schema version.
+// The tables have no primary key so they can be turned into hypertables.
+var postgresMigrations = []string{
+	`CREATE TABLE responses (
+		msm_id      INTEGER NOT NULL,
+		role        TEXT NOT NULL,
+		prb_id      INTEGER NOT NULL,
+		timestamp   TIMESTAMPTZ NOT NULL,
+		dst         TEXT,
+		af          SMALLINT,
+		rcode       TEXT,
+		denial      TEXT,
+		flags       TEXT,
+		neg_ttl     BIGINT,
+		soa_minimum BIGINT,
+		rt          DOUBLE PRECISION,
+		size        INTEGER,
+		abuf        TEXT
+	);
+	CREATE INDEX responses_timestamp ON responses (timestamp);
+	CREATE INDEX responses_probe ON responses (prb_id, timestamp);
+	CREATE INDEX responses_msm ON responses (msm_id, timestamp);
+	CREATE TABLE points (
+		time   TIMESTAMPTZ NOT NULL,
+		name   TEXT NOT NULL,
+		tags   JSONB NOT NULL,
+		fields JSONB NOT NULL
+	);
+	CREATE INDEX points_name ON points (name, time);
+	CREATE INDEX points_tags ON points USING GIN (tags);`,
+}
+
+// postgresSink writes responses and points to PostgreSQL, optionally
+// with TimescaleDB hypertables
+type postgresSink struct {
+	access    sync.Mutex
+	db        *sql.DB
+	responses bool
+}
+
+func init() {
+	sinkTypes["postgres"] = newPostgresSink
+}
+
+// newPostgresSink connects to dsn and applies missing migrations.
+// With timescale the tables are converted to hypertables, chunkinterval
+// sets the size of the chunks. With responses false only points are written.
+//
+//	sinks:
+//	  - type: postgres
+//	    dsn: postgres://nsecmonitor@localhost/nsecmonitor?sslmode=disable
+//	    timescale: true
+//	    chunkinterval: 24h
+func newPostgresSink(name string, conf *viper.Viper) (Sink, error) {
+	if len(conf.GetString("dsn")) == 0 {
+		return nil, fmt.Errorf("dsn must be given")
+	}
+	db, err := sql.Open("postgres", conf.GetString("dsn"))
+	if err != nil {
+		return nil, err
+	}
+	if err = db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err = migratePostgres(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if conf.GetBool("timescale") {
+		chunk := conf.GetDuration("chunkinterval")
+		if chunk <= 0 {
+			chunk = 24 * time.Hour
+		}
+		if err = createHypertables(db, chunk); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
+	responses := true
+	if conf.IsSet("responses") {
+		responses = conf.GetBool("responses")
+	}
+	return &postgresSink{db: db, responses: responses}, nil
+}
+
+// migratePostgres brings the schema to the latest version
+func migratePostgres(db *sql.DB) error {
+	if _, err := db.Exec("CREATE TABLE IF NOT EXISTS schema_version (version INTEGER NOT NULL)"); err != nil {
+		return err
+	}
+	var version int
+	if err := db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_version").Scan(&version); err != nil {
+		return err
+	}
+
+	for v := version; v < len(postgresMigrations); v++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err = tx.Exec(postgresMigrations[v]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %s", v+1, err)
+		}
+		if _, err = tx.Exec("INSERT INTO schema_version (version) VALUES ($1)", v+1); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err = tx.Commit(); err != nil {
+			return err
+		}
+		if verbose > 0 {
+			log.Printf("Database migrated to version %d", v+1)
+		}
+	}
+	return nil
+}
+
+// createHypertables turns the tables into TimescaleDB hypertables,
+// tables that already are hypertables are left alone
+func createHypertables(db *sql.DB, chunk time.Duration) error {
+	if _, err := db.Exec("CREATE EXTENSION IF NOT EXISTS timescaledb"); err != nil {
+		return err
+	}
+	interval := fmt.Sprintf("%d seconds", int64(chunk.Seconds()))
+	for table, column := range map[string]string{"responses": "timestamp", "points": "time"} {
+		_, err := db.Exec("SELECT create_hypertable($1, $2, chunk_time_interval => $3::interval, if_not_exists => TRUE, migrate_data => TRUE)",
+			table, column, interval)
+		if err != nil {
+			return fmt.Errorf("hypertable %s: %s", table, err)
+		}
+	}
+	return nil
+}
+
+// Write copies the points into the points table, tags and fields as JSON
+func (s *postgresSink) Write(points []*Point) error {
+	if len(points) == 0 {
+		return nil
+	}
+	return s.copy("points", []string{"time", "name", "tags", "fields"}, len(points), func(i int) ([]interface{}, error) {
+		p := points[i]
+		tags, err := json.Marshal(p.Tags)
+		if err != nil {
+			return nil, err
+		}
+		fields, err := json.Marshal(finiteFields(p.Fields))
+		if err != nil {
+			return nil, err
+		}
+		return []interface{}{p.Time, p.Name, string(tags), string(fields)}, nil
+	})
+}
+
+// finiteFields returns the fields without NaN and Inf, JSON has no numbers for them
+func finiteFields(fields map[string]interface{}) map[string]interface{} {
+	finite := make(map[string]interface{}, len(fields))
+	for k, v := range fields {
+		switch f := v.(type) {
+		case float64:
+			if math.IsNaN(f) || math.IsInf(f, 0) {
+				continue
+			}
+		case float32:
+			if math.IsNaN(float64(f)) || math.IsInf(float64(f), 0) {
+				continue
+			}
+		}
+		finite[k] = v
+	}
+	return finite
+}
+
+// WriteResponses copies the responses into the responses table
+func (s *postgresSink) WriteResponses(responses []*Response) error {
+	if !s.responses {
+		return nil
+	}
+	columns := []string{"msm_id", "role", "prb_id", "timestamp", "dst", "af", "rcode", "denial",
+		"flags", "neg_ttl", "soa_minimum", "rt", "size", "abuf"}
+	return s.copy("responses", columns, len(responses), func(i int) ([]interface{}, error) {
+		r := responses[i]
+		return []interface{}{r.MsmID, r.Role, r.PrbID, r.Timestamp, r.Dst, r.Af, r.Rcode, r.Denial,
+			r.Flags, r.NegTTL, r.SOAMinimum, r.Rt, r.Size, r.Abuf}, nil
+	})
+}
+
+// copy writes n rows with COPY in one transaction
+func (s *postgresSink) copy(table string, columns []string, n int, row func(i int) ([]interface{}, error)) error {
+	s.access.Lock()
+	defer s.access.Unlock()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare(pq.CopyIn(table, columns...))
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	for i := 0; i < n; i++ {
+		values, err := row(i)
+		if err != nil {
+			stmt.Close()
+			tx.Rollback()
+			return err
+		}
+		if _, err = stmt.Exec(values...); err != nil {
+			stmt.Close()
+			tx.Rollback()
+			return err
+		}
+	}
+	// flush the copy buffer
+	if _, err = stmt.Exec(); err != nil {
+		stmt.Close()
+		tx.Rollback()
+		return err
+	}
+	if err = stmt.Close(); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *postgresSink) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,149 @@
+package cmd
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func TestFiniteFields(t *testing.T) {
+	fields := map[string]interface{}{
+		"count": 3,
+		"share": 0.5,
+		"nan":   math.NaN(),
+		"inf":   math.Inf(-1),
+		"f32":   float32(math.Inf(1)),
+		"state": "validating",
+	}
+	got := finiteFields(fields)
+	if len(got) != 3 || got["count"] != 3 || got["share"] != 0.5 || got["state"] != "validating" {
+		t.Errorf("got %v", got)
+	}
+	if len(fields) != 6 {
+		t.Error("fields of the point were changed")
+	}
+}
+
+// testPostgres connects to NSECM_TEST_POSTGRES_DSN and returns a dsn
+// that uses a new schema, the schema is dropped after the test
+func testPostgres(t *testing.T) string {
+	dsn := os.Getenv("NSECM_TEST_POSTGRES_DSN")
+	if len(dsn) == 0 {
+		t.Skip("NSECM_TEST_POSTGRES_DSN not set")
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := fmt.Sprintf("nsecm_test_%d", time.Now().UnixNano())
+	if _, err = db.Exec("CREATE SCHEMA " + schema); err != nil {
+		db.Close()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Exec("DROP SCHEMA " + schema + " CASCADE")
+		db.Close()
+	})
+
+	switch {
+	case !strings.Contains(dsn, "://"):
+		return dsn + " search_path=" + schema
+	case strings.Contains(dsn, "?"):
+		return dsn + "&search_path=" + schema
+	}
+	return dsn + "?search_path=" + schema
+}
+
+func testPostgresSink(t *testing.T, dsn string, responses bool) *postgresSink {
+	conf := viper.New()
+	conf.Set("dsn", dsn)
+	conf.Set("responses", responses)
+	s, err := newPostgresSink("test", conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s.(*postgresSink)
+}
+
+func TestPostgresMigrations(t *testing.T) {
+	dsn := testPostgres(t)
+
+	s := testPostgresSink(t, dsn, true)
+	s.Close()
+	s = testPostgresSink(t, dsn, true)
+	defer s.Close()
+
+	var n, version int
+	if err := s.db.QueryRow("SELECT COUNT(*), MAX(version) FROM schema_version").Scan(&n, &version); err != nil {
+		t.Fatal(err)
+	}
+	if n != len(postgresMigrations) || version != len(postgresMigrations) {
+		t.Errorf("got %d versions up to %d, want %d", n, version, len(postgresMigrations))
+	}
+}
+
+func TestPostgresWrite(t *testing.T) {
+	s := testPostgresSink(t, testPostgres(t), true)
+	defer s.Close()
+
+	now := time.Now().Truncate(time.Second)
+	points := []*Point{
+		{Name: "staticRcodes", Tags: map[string]string{"role": "static"}, Fields: map[string]interface{}{"NOERROR": 10, "share": math.NaN()}, Time: now},
+		{Name: "invalidValidation", Tags: map[string]string{}, Fields: map[string]interface{}{"share": 0.25}, Time: now},
+	}
+	if err := s.Write(points); err != nil {
+		t.Fatal(err)
+	}
+	var fields string
+	if err := s.db.QueryRow("SELECT fields::text FROM points WHERE name = 'staticRcodes'").Scan(&fields); err != nil {
+		t.Fatal(err)
+	}
+	if fields != `{"NOERROR": 10}` {
+		t.Errorf("got fields %s", fields)
+	}
+	var share float64
+	if err := s.db.QueryRow("SELECT (fields->>'share')::float FROM points WHERE tags->>'role' IS NULL").Scan(&share); err != nil {
+		t.Fatal(err)
+	}
+	if share != 0.25 {
+		t.Errorf("got share %g", share)
+	}
+
+	responses := []*Response{
+		{MsmID: 1, Role: "static", PrbID: 2, Timestamp: now, Dst: "192.0.2.1", Af: 4, Rcode: "NOERROR", Rt: 12.5},
+		{MsmID: 1, Role: "static", PrbID: 3, Timestamp: now, Dst: "2001:db8::1", Af: 6, Rcode: "SERVFAIL"},
+	}
+	if err := s.WriteResponses(responses); err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	var ts time.Time
+	if err := s.db.QueryRow("SELECT COUNT(*), MAX(timestamp) FROM responses").Scan(&n, &ts); err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 || !ts.Equal(now) {
+		t.Errorf("got %d responses at %s", n, ts)
+	}
+}
+
+func TestPostgresNoResponses(t *testing.T) {
+	s := testPostgresSink(t, testPostgres(t), false)
+	defer s.Close()
+
+	if err := s.WriteResponses([]*Response{{MsmID: 1, Role: "static", Timestamp: time.Now()}}); err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM responses").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("got %d responses, want 0", n)
+	}
+}
@@ -0,0 +1,358 @@
+package cmd
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// probeInfo contains the probe metadata we use to group results
+type probeInfo struct {
+	ID      int    `json:"id"`
+	Country string `json:"country_code"`
+	ASNv4   int    `json:"asn_v4"`
+	ASNv6   int    `json:"asn_v6"`
+	Region  string `json:"-"`
+}
+
+// probeKey is the probe metadata every metric is tagged with
+type probeKey struct {
+	Country string
+	Region  string
+	ASNv4   int
+	ASNv6   int
+}
+
+// probeArchive is the format of the Ripe Atlas probe archive
+type probeArchive struct {
+	Objects []*probeInfo `json:"objects"`
+}
+
+// default regions, the countries we request probes for
+var defaultRegions = map[string][]string{
+	"nordic": {"SE", "NO", "FI", "DK", "IS"},
+}
+
+// PROBERETRY is how long a failed probe lookup is not retried
+const PROBERETRY = time.Hour
+
+// PROBEWAIT is how long unknown probes are collected before they are
+// looked up, PROBEBACKOFF the longest wait after failed API calls
+const (
+	PROBEWAIT    = 5 * time.Second
+	PROBEBACKOFF = 10 * time.Minute
+)
+
+var probeCache = make(map[int]*probeInfo)
+var probeGroups = make(map[int]probeKey)
+var probePending = make(map[int]bool)
+var probeFailed = make(map[int]time.Time)
+var probeRefresh = sync.Once{}
+var accessProbes = sync.Mutex{}
+
+// getProbe returns the metadata of a probe or nil if it is not known yet.
+// Unknown probes are fetched from the Ripe Atlas API in the background,
+// unless a probe file is given. Failed lookups are retried after PROBERETRY.
+func getProbe(id int) *probeInfo {
+	probeRefresh.Do(startProbeRefresh)
+
+	accessProbes.Lock()
+	defer accessProbes.Unlock()
+
+	if p, ok := probeCache[id]; ok {
+		return p
+	}
+	if len(viper.GetString("probefile")) > 0 {
+		// the probe file is all we know
+		return nil
+	}
+	if failed, ok := probeFailed[id]; ok && time.Since(failed) < PROBERETRY {
+		return nil
+	}
+	// looked up by probeWorker
+	probePending[id] = true
+	return nil
+}
+
+// probeTags returns the metadata the probe is counted with, false if it is
+// not known yet. The metadata is fixed once it is known or the lookup failed,
+// so cumulative counts never move from one group to another.
+func probeTags(id int) (probeKey, bool) {
+	p := getProbe(id)
+
+	accessProbes.Lock()
+	defer accessProbes.Unlock()
+
+	if k, ok := probeGroups[id]; ok {
+		return k, true
+	}
+	k := probeKey{}
+	if p != nil {
+		k = probeKey{Country: p.Country, Region: p.Region, ASNv4: p.ASNv4, ASNv6: p.ASNv6}
+	} else if _, failed := probeFailed[id]; !failed && len(viper.GetString("probefile")) == 0 {
+		return k, false
+	}
+	probeGroups[id] = k
+	return k, true
+}
+
+// tags returns the influx tags, unknown values are tagged "unknown"
+func (k probeKey) tags() map[string]string {
+	tags := map[string]string{
+		"country": "unknown",
+		"region":  "unknown",
+		"asn_v4":  "unknown",
+		"asn_v6":  "unknown",
+	}
+	if len(k.Country) > 0 {
+		tags["country"] = k.Country
+	}
+	if len(k.Region) > 0 {
+		tags["region"] = k.Region
+	}
+	if k.ASNv4 > 0 {
+		tags["asn_v4"] = strconv.Itoa(k.ASNv4)
+	}
+	if k.ASNv6 > 0 {
+		tags["asn_v6"] = strconv.Itoa(k.ASNv6)
+	}
+	return tags
+}
+
+// startProbeRefresh loads the probe archive if one is configured, otherwise
+// unknown probes are looked up in the background. The probe metadata is
+// refreshed every "proberefresh".
+func startProbeRefresh() {
+	if len(viper.GetString("probefile")) > 0 {
+		loadProbeFile(viper.GetString("probefile"))
+	} else {
+		go probeWorker()
+	}
+
+	refresh := viper.GetDuration("proberefresh")
+	if refresh <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(refresh)
+		for range ticker.C {
+			if len(viper.GetString("probefile")) > 0 {
+				loadProbeFile(viper.GetString("probefile"))
+				continue
+			}
+
+			accessProbes.Lock()
+			ids := make([]int, 0, len(probeCache))
+			for id := range probeCache {
+				ids = append(ids, id)
+			}
+			accessProbes.Unlock()
+
+			if verbose > 1 {
+				log.Printf("Refreshing %d probes", len(ids))
+			}
+			if err := fetchProbes(ids); err != nil {
+				log.Printf("Could not refresh probes: %s", err)
+			}
+		}
+	}()
+}
+
+// loadProbeFile reads a probe archive dump, plain or compressed with bzip2 or gzip
+func loadProbeFile(filename string) {
+	f, err := os.Open(filename)
+	if err != nil {
+		log.Printf("Could not open probe file %s: %s", filename, err)
+		return
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	switch {
+	case strings.HasSuffix(filename, ".bz2"):
+		r = bzip2.NewReader(f)
+	case strings.HasSuffix(filename, ".gz"):
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			log.Printf("Could not read probe file %s: %s", filename, err)
+			return
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	body, err := ioutil.ReadAll(r)
+	if err != nil {
+		log.Printf("Could not read probe file %s: %s", filename, err)
+		return
+	}
+
+	// archive dumps are an object, api dumps may be a list
+	archive := &probeArchive{}
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		err = json.Unmarshal(body, &archive.Objects)
+	} else {
+		err = json.Unmarshal(body, archive)
+	}
+	if err != nil {
+		log.Printf("Could not parse probe file %s: %s", filename, err)
+		return
+	}
+
+	accessProbes.Lock()
+	defer accessProbes.Unlock()
+	for _, p := range archive.Objects {
+		p.Region = region(p.Country)
+		probeCache[p.ID] = p
+	}
+	if verbose > 0 {
+		log.Printf("Loaded %d probes from %s", len(archive.Objects), filename)
+	}
+}
+
+// PROBEBATCH is the number of probes looked up with one API call
+const PROBEBATCH = 500
+
+// probeWorker looks up the pending probes every PROBEWAIT, after
+// failed API calls it waits longer up to PROBEBACKOFF
+func probeWorker() {
+	wait := PROBEWAIT
+	for {
+		time.Sleep(wait)
+
+		accessProbes.Lock()
+		ids := make([]int, 0, len(probePending))
+		for id := range probePending {
+			ids = append(ids, id)
+		}
+		accessProbes.Unlock()
+		if len(ids) == 0 {
+			continue
+		}
+
+		if err := fetchProbes(ids); err != nil {
+			log.Printf("Could not get %d probes: %s", len(ids), err)
+			wait *= 2
+			if wait > PROBEBACKOFF {
+				wait = PROBEBACKOFF
+			}
+			continue
+		}
+		wait = PROBEWAIT
+	}
+}
+
+// fetchProbes gets the metadata of many probes with as few API calls as
+// possible. Probes the API does not return are recorded as failed, if an
+// API call fails the probes stay pending.
+func fetchProbes(ids []int) error {
+	for len(ids) > 0 {
+		batch := ids
+		if len(batch) > PROBEBATCH {
+			batch = batch[:PROBEBATCH]
+		}
+		ids = ids[len(batch):]
+
+		probes, err := loadProbes(batch)
+		if err != nil {
+			return err
+		}
+		if verbose > 1 {
+			log.Printf("Fetched %d of %d probes", len(probes), len(batch))
+		}
+
+		accessProbes.Lock()
+		for _, p := range probes {
+			p.Region = region(p.Country)
+			probeCache[p.ID] = p
+		}
+		for _, id := range batch {
+			delete(probePending, id)
+			if _, ok := probeCache[id]; ok {
+				delete(probeFailed, id)
+			} else {
+				probeFailed[id] = time.Now()
+			}
+		}
+		accessProbes.Unlock()
+	}
+	return nil
+}
+
+// loadProbes gets the metadata of the probes from the Ripe Atlas API,
+// following the pages of the result
+func loadProbes(ids []int) ([]*probeInfo, error) {
+	client := &http.Client{Timeout: 60 * time.Second}
+
+	list := make([]string, len(ids))
+	for i, id := range ids {
+		list[i] = strconv.Itoa(id)
+	}
+	next := fmt.Sprintf("https://atlas.ripe.net/api/v2/probes/?id__in=%s&page_size=%d&fields=id,country_code,asn_v4,asn_v6",
+		strings.Join(list, ","), PROBEBATCH)
+
+	probes := make([]*probeInfo, 0, len(ids))
+	for len(next) > 0 {
+		resp, err := client.Get(next)
+		if err != nil {
+			return probes, err
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return probes, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return probes, fmt.Errorf("API call returned status %s", resp.Status)
+		}
+
+		page := struct {
+			Next    string       `json:"next"`
+			Results []*probeInfo `json:"results"`
+		}{}
+		if err = json.Unmarshal(body, &page); err != nil {
+			return probes, err
+		}
+		probes = append(probes, page.Results...)
+		next = page.Next
+	}
+	return probes, nil
+}
+
+// region returns the configured region of a country
+func region(country string) string {
+	regions := defaultRegions
+	if viper.IsSet("regions") {
+		regions = viper.GetStringMapStringSlice("regions")
+	}
+	for name, countries := range regions {
+		for _, c := range countries {
+			if strings.EqualFold(c, country) {
+				return name
+			}
+		}
+	}
+	return "other"
+}
+
+// asn returns the probe ASN for the given address family
+func (p *probeInfo) asn(af int) int {
+	if af == 6 {
+		return p.ASNv6
+	}
+	return p.ASNv4
+}
@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestProbeTagsFixed(t *testing.T) {
+	// no background lookups
+	probeRefresh.Do(func() {})
+
+	if _, ok := probeTags(1001); ok {
+		t.Fatal("probe without metadata must wait")
+	}
+	accessProbes.Lock()
+	pending := probePending[1001]
+	probeCache[1001] = &probeInfo{ID: 1001, Country: "SE", Region: "nordic", ASNv4: 3301}
+	accessProbes.Unlock()
+	if !pending {
+		t.Error("unknown probe not queued for lookup")
+	}
+
+	k, ok := probeTags(1001)
+	if !ok || k.Country != "SE" || k.ASNv4 != 3301 {
+		t.Fatalf("got %+v %v", k, ok)
+	}
+
+	// changed metadata does not move the probe
+	accessProbes.Lock()
+	probeCache[1001] = &probeInfo{ID: 1001, Country: "NO", Region: "nordic"}
+	accessProbes.Unlock()
+	if k, _ = probeTags(1001); k.Country != "SE" {
+		t.Errorf("probe moved to %s", k.Country)
+	}
+
+	// failed lookups are counted as unknown
+	accessProbes.Lock()
+	probeFailed[1002] = time.Now()
+	accessProbes.Unlock()
+	k, ok = probeTags(1002)
+	if !ok || k != (probeKey{}) {
+		t.Errorf("got %+v %v for failed probe", k, ok)
+	}
+	if k.tags()["country"] != "unknown" {
+		t.Errorf("got country %s", k.tags()["country"])
+	}
+}
@@ -0,0 +1,190 @@
+package cmd
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/spf13/viper"
+)
+
+// labels of the response and denial counters
+var promLabels = []string{"role", "server", "addr", "af", "country", "region", "asn_v4", "asn_v6"}
+var promResolverLabels = []string{"role", "resolver", "resolver_asn"}
+
+var (
+	promResponses = prometheus.NewDesc("nsecmonitor_responses_total",
+		"Responses by rcode.", append(promLabels, "rcode"), nil)
+	promDenial = prometheus.NewDesc("nsecmonitor_denial_total",
+		"Responses by denial of existence type.", append(promLabels, "denial"), nil)
+	promResolverResponses = prometheus.NewDesc("nsecmonitor_resolver_responses_total",
+		"Responses by resolver and rcode.", append(promResolverLabels, "rcode"), nil)
+	promResolverDenial = prometheus.NewDesc("nsecmonitor_resolver_denial_total",
+		"Responses by resolver and denial of existence type.", append(promResolverLabels, "denial"), nil)
+	promErrors = prometheus.NewDesc("nsecmonitor_errors_total",
+		"Errors while handling results.", []string{"role", "kind"}, nil)
+)
+
+// promEnabled is set once a prometheus sink is configured
+var promEnabled atomic.Bool
+
+// promRtt is observed for every result if a prometheus sink is configured
+var promRtt = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "nsecmonitor_response_time_milliseconds",
+	Help:    "Response times reported by the probes.",
+	Buckets: rttBounds,
+}, []string{"role", "server", "af"})
+
+// prometheusSink keeps the last value of every counter and
+// serves them on an http listener
+type prometheusSink struct {
+	access sync.Mutex
+	points map[string]*Point
+	server *http.Server
+}
+
+func init() {
+	sinkTypes["prometheus"] = newPrometheusSink
+}
+
+// newPrometheusSink creates a sink from listen and path
+func newPrometheusSink(name string, conf *viper.Viper) (Sink, error) {
+	listen := conf.GetString("listen")
+	if len(listen) == 0 {
+		listen = ":9153"
+	}
+	path := conf.GetString("path")
+	if len(path) == 0 {
+		path = "/metrics"
+	}
+
+	s := &prometheusSink{points: make(map[string]*Point)}
+	registry := prometheus.NewRegistry()
+	if err := registry.Register(s); err != nil {
+		return nil, err
+	}
+	if err := registry.Register(promRtt); err != nil {
+		return nil, err
+	}
+	promEnabled.Store(true)
+
+	mux := http.NewServeMux()
+	mux.Handle(path, promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	s.server = &http.Server{Addr: listen, Handler: mux}
+	go func() {
+		if err := s.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Prometheus listener %s: %s", name, err)
+		}
+	}()
+	if verbose > 0 {
+		log.Printf("Serving prometheus metrics on %s%s", listen, path)
+	}
+	return s, nil
+}
+
+// Write keeps the points, they are exported when scraped
+func (s *prometheusSink) Write(points []*Point) error {
+	s.access.Lock()
+	defer s.access.Unlock()
+
+	for _, p := range points {
+		s.points[pointID(p.Name, p.Tags)] = p
+	}
+	return nil
+}
+
+func (s *prometheusSink) Close() error {
+	return s.server.Close()
+}
+
+// Describe implements prometheus.Collector
+func (s *prometheusSink) Describe(ch chan<- *prometheus.Desc) {
+	ch <- promResponses
+	ch <- promDenial
+	ch <- promResolverResponses
+	ch <- promResolverDenial
+	ch <- promErrors
+}
+
+// Collect implements prometheus.Collector
+func (s *prometheusSink) Collect(ch chan<- prometheus.Metric) {
+	s.access.Lock()
+	defer s.access.Unlock()
+
+	for _, p := range s.points {
+		switch {
+		case p.Name == "errors":
+			for kind, v := range p.Fields {
+				collectCounter(ch, promErrors, v, p.Tags["role"], kind)
+			}
+		case p.Name == "resolverRcodes":
+			for rcode, v := range p.Fields {
+				collectCounter(ch, promResolverResponses, v, append(labelValues(p.Tags, promResolverLabels), rcode)...)
+			}
+		case p.Name == "resolverNsec":
+			for denial, v := range p.Fields {
+				collectCounter(ch, promResolverDenial, v, append(labelValues(p.Tags, promResolverLabels), strings.ToUpper(denial))...)
+			}
+		case strings.HasSuffix(p.Name, "Rcodes"):
+			tags := roleTags(p)
+			for rcode, v := range p.Fields {
+				collectCounter(ch, promResponses, v, append(labelValues(tags, promLabels), rcode)...)
+			}
+		case strings.HasSuffix(p.Name, "Nsec"):
+			tags := roleTags(p)
+			for denial, v := range p.Fields {
+				collectCounter(ch, promDenial, v, append(labelValues(tags, promLabels), strings.ToUpper(denial))...)
+			}
+		}
+	}
+}
+
+// roleTags adds the role, taken from the measurement name, to the tags
+func roleTags(p *Point) map[string]string {
+	tags := map[string]string{
+		"role": strings.TrimSuffix(strings.TrimSuffix(p.Name, "Rcodes"), "Nsec"),
+	}
+	for k, v := range p.Tags {
+		tags[k] = v
+	}
+	return tags
+}
+
+// labelValues returns the tag values in label order, missing tags are empty
+func labelValues(tags map[string]string, labels []string) []string {
+	values := make([]string, len(labels))
+	for i, l := range labels {
+		values[i] = tags[l]
+	}
+	return values
+}
+
+func collectCounter(ch chan<- prometheus.Metric, desc *prometheus.Desc, v interface{}, labels ...string) {
+	n, ok := number(v)
+	if !ok {
+		return
+	}
+	m, err := prometheus.NewConstMetric(desc, prometheus.CounterValue, n, labels...)
+	if err != nil {
+		log.Printf("Could not create metric %s: %s", desc, err)
+		return
+	}
+	ch <- m
+}
+
+// observeRtt adds a response time to the prometheus histogram. The
+// resolvers of the probes are bucketed like the resolver counters.
+func observeRtt(role string, server string, af int, rt float64) {
+	if !promEnabled.Load() {
+		return
+	}
+	if role != "auth" {
+		server = resolverName(server)
+	}
+	promRtt.WithLabelValues(role, server, strconv.Itoa(af)).Observe(rt)
+}
@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	mdns "github.com/miekg/dns"
+	"github.com/spf13/viper"
+)
+
+const (
+	QNAMEOK         = "ok"
+	QNAMENOQUESTION = "noquestion"
+	QNAMEFORMAT     = "format"
+	QNAMEPROBE      = "probe"
+	QNAMETIME       = "time"
+	QNAMEBASE       = "base"
+)
+
+// macroName is a query name built from "$r-$p-$t-<base>"
+type macroName struct {
+	Random    string
+	Probe     int
+	Timestamp int64
+	Base      string
+}
+
+var statsQname = make(map[string]int)
+var accessQname = sync.Mutex{}
+
+// parseMacroName splits the query name into its macro components
+func parseMacroName(qname string) (*macroName, bool) {
+	parts := strings.SplitN(strings.ToLower(qname), "-", 4)
+	if len(parts) != 4 {
+		return nil, false
+	}
+	if _, err := strconv.ParseUint(parts[0], 16, 64); err != nil {
+		return nil, false
+	}
+	probe, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return nil, false
+	}
+	ts, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return nil, false
+	}
+	return &macroName{Random: parts[0], Probe: probe, Timestamp: ts, Base: parts[3]}, true
+}
+
+// checkQname compares the question of the response with probe id and
+// timestamp of the result. Every mismatch found is counted.
+func checkQname(prbid int, timestamp int, msg *mdns.Msg) {
+	mismatches := make([]string, 0)
+	qname := ""
+
+	if len(msg.Question) == 0 {
+		mismatches = append(mismatches, QNAMENOQUESTION)
+	} else if name, ok := parseMacroName(msg.Question[0].Name); !ok {
+		qname = msg.Question[0].Name
+		mismatches = append(mismatches, QNAMEFORMAT)
+	} else {
+		qname = msg.Question[0].Name
+		if name.Probe != prbid {
+			mismatches = append(mismatches, QNAMEPROBE)
+		}
+		diff := time.Duration(int64(timestamp)-name.Timestamp) * time.Second
+		if diff < 0 {
+			diff = -diff
+		}
+		if diff > viper.GetDuration("qnametolerance") {
+			mismatches = append(mismatches, QNAMETIME)
+		}
+		base := mdns.Fqdn(strings.ToLower(viper.GetString("randombase")))
+		if len(viper.GetString("randombase")) > 0 && name.Base != base {
+			mismatches = append(mismatches, QNAMEBASE)
+		}
+	}
+
+	accessQname.Lock()
+	defer accessQname.Unlock()
+
+	if len(mismatches) == 0 {
+		statsQname[QNAMEOK]++
+		return
+	}
+	for _, m := range mismatches {
+		statsQname[m]++
+	}
+	if verbose > 1 {
+		log.Printf("Probe %d got answer for %s at %d: %v", prbid, qname, timestamp, mismatches)
+	}
+}
+
+// addQnamePoints adds the number of matching and mismatching query names
+func addQnamePoints(bp *Batch, now time.Time) {
+	accessQname.Lock()
+	defer accessQname.Unlock()
+
+	// values
+	fields := map[string]interface{}{}
+	for _, f := range []string{QNAMEOK, QNAMENOQUESTION, QNAMEFORMAT, QNAMEPROBE, QNAMETIME, QNAMEBASE} {
+		fields[f] = statsQname[f]
+	}
+	addPoint(bp, "randomQname", map[string]string{}, fields, now)
+}
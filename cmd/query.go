@@ -0,0 +1,126 @@
+/*
+Copyright © 2020 Ulrich Wisser <ulrich@wisser.se>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// queryCmd represents the query command
+var queryCmd = &cobra.Command{
+	Use:   "query SQL",
+	Short: "run a sql query on the stored responses",
+	Long: `run a sql query on the stored responses
+
+Example:
+  nsecmonitor query "SELECT prb_id, COUNT(*) FROM responses WHERE role='invalid' AND rcode='NOERROR' GROUP BY prb_id"`,
+	Args: cobra.MinimumNArgs(1),
+	Run:  runQuery,
+}
+
+func init() {
+	rootCmd.AddCommand(queryCmd)
+	queryCmd.Flags().String("db", "", "database file (default is the first sqlite sink)")
+}
+
+func runQuery(cmd *cobra.Command, args []string) {
+	file, _ := cmd.Flags().GetString("db")
+	if len(file) == 0 {
+		file = sqliteFile()
+	}
+	if len(file) == 0 {
+		log.Fatal("No database given and no sqlite sink configured")
+	}
+
+	db, err := openSQLiteReadOnly(file)
+	if err != nil {
+		log.Fatal("Could not open database. ", err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query(strings.Join(args, " "))
+	if err != nil {
+		log.Fatal("Query failed. ", err)
+	}
+	defer rows.Close()
+
+	if err = printRows(rows); err != nil {
+		log.Fatal("Could not read result. ", err)
+	}
+}
+
+// sqliteFile returns the file of the first sqlite sink in the config file
+func sqliteFile() string {
+	confs := make([]map[string]interface{}, 0)
+	if err := viper.UnmarshalKey("sinks", &confs); err != nil {
+		return ""
+	}
+	for _, c := range confs {
+		if c["type"] == "sqlite" {
+			if file, ok := c["file"].(string); ok {
+				return file
+			}
+		}
+	}
+	return ""
+}
+
+// printRows prints the result as tab separated table with header
+func printRows(rows *sql.Rows) error {
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(columns, "\t"))
+
+	values := make([]interface{}, len(columns))
+	pointers := make([]interface{}, len(columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	for rows.Next() {
+		if err = rows.Scan(pointers...); err != nil {
+			return err
+		}
+		line := make([]string, len(columns))
+		for i, v := range values {
+			switch b := v.(type) {
+			case nil:
+				line[i] = "NULL"
+			case []byte:
+				line[i] = string(b)
+			default:
+				line[i] = fmt.Sprint(b)
+			}
+		}
+		fmt.Fprintln(w, strings.Join(line, "\t"))
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	return w.Flush()
+}
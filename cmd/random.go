@@ -24,18 +24,13 @@ import (
 	"github.com/DNS-OARC/ripeatlas/measurement"
 	"github.com/DNS-OARC/ripeatlas/measurement/dns"
 
-	_ "github.com/influxdata/influxdb1-client" // this is important because of the bug in go mod
-	client "github.com/influxdata/influxdb1-client/v2"
-
-	mdns "github.com/miekg/dns"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
-var statsRandomRcode [32]int
-var statsRandomNSEC int
-var statsRandomNSEC3 int
-var statsRandomNONSEC int
+var statsRandom = newProbeCounts()
+var statsRandomAD = newADStats()
+var statsRandomSOA = newSOAStats()
 
 // randomCmd represents the random command
 var randomCmd = &cobra.Command{
@@ -47,6 +42,9 @@ var randomCmd = &cobra.Command{
 
 func init() {
 	rootCmd.AddCommand(randomCmd)
+	randomCmd.Flags().Uint32("negttl", 0, "negative TTL served by the authoritative servers (0=learn from responses)")
+	randomCmd.Flags().String("randombase", "", "base name of the random query names (empty=not checked)")
+	randomCmd.Flags().Duration("qnametolerance", 10*time.Minute, "allowed difference between query name and result timestamp")
 
 	// Use flags for viper values
 	viper.BindPFlags(randomCmd.Flags())
@@ -83,12 +81,7 @@ func runRandom(cmd *cobra.Command, args []string) {
 }
 
 func checkRandomConf() {
-	if len(viper.GetString("influxserver")) == 0 {
-		log.Println("Influx server must be given")
-	}
-	if len(viper.GetString("influxdb")) == 0 {
-		log.Println("Influx database must be given")
-	}
+	checkSinkConf()
 }
 
 func rcvRandom(ch <-chan *measurement.Result) {
@@ -99,6 +92,7 @@ func rcvRandom(ch <-chan *measurement.Result) {
 		// if parsing fails
 		if msm.ParseError != nil {
 			log.Println(msm.ParseError.Error())
+			addError("random", PARSEERROR)
 			continue
 		}
 
@@ -113,114 +107,58 @@ func rcvRandom(ch <-chan *measurement.Result) {
 			log.Printf("%d %s", msm.MsmId(), msm.Type())
 		}
 
-		// handle single result
-		if msm.DnsResult() != nil {
-			handleRandom(msm.DnsResult())
-		}
-		for _, s := range msm.DnsResultsets() {
-			if s.Result() != nil {
-				handleRandom(s.Result())
-			}
-		}
+		// handle the result once its window is closed
+		addResult(msm, handleRandom)
 	}
 
 }
 
-func handleRandom(result *dns.Result) {
+func handleRandom(msm *measurement.Result, dst string, result *dns.Result) {
 
 	msg, err := result.UnpackAbuf()
 	if err != nil {
 		log.Println("Could not unpack Abuf ", err)
+		addError("random", ABUFERROR)
 		return
 	}
-	statsRandomRcode[msg.Rcode]++
-	switch nsec(msg.Ns) {
-	case NSEC:
-		statsRandomNSEC++
-	case NSEC3:
-		statsRandomNSEC3++
-	case NONSEC:
-		statsRandomNONSEC++
-	}
+	storeResponse("random", msm, dst, result, msg)
+	statsRandom.add(msm.PrbId(), msg)
+	addResolver("random", msm.PrbId(), msm.Af(), dst, msg)
+	addRtt("random", msm.PrbId(), msm.Af(), dst, result.Rt())
+	addSize("random", msm.MsmId(), result.Size(), msg)
+	checkQname(msm.PrbId(), msm.Timestamp(), msg)
+	statsRandomAD.add(msm.PrbId(), msg)
+	statsRandomSOA.add(msm.MsmId(), msg)
+	updateAggressive(msm.PrbId(), msm.Af(), msg, time.Unix(int64(msm.Timestamp()), 0))
 }
 
 func saveRandomStats() {
-	// influxdb client config
-	conf := client.HTTPConfig{
-		Addr: viper.GetString("influxserver"),
-	}
-	if len(viper.GetString("influxuser")) > 0 {
-		conf.Username = viper.GetString("influxuser")
-		conf.Password = viper.GetString("influxpasswd")
-	}
-
-	// get access to Influx
-	influx, err := client.NewHTTPClient(conf)
-	if err != nil {
-		log.Fatalf("Error creating InfluxDB Client: %s", err.Error())
-	}
-
-	ticker := time.NewTicker(UPDATEINTERVAL)
-	for {
-		now := <-ticker.C
-
-		bp, err := client.NewBatchPoints(client.BatchPointsConfig{
-			Database:  viper.GetString("influxdb"),
-			Precision: "s",
-		})
-		if err != nil {
-			log.Fatalf("Could not create new batch points: %s", err.Error())
-		}
+	saveStats(func(bp *Batch, now time.Time) {
+		// rcodes and denial types
+		statsRandom.addPoints(bp, "random", true, now)
 
-		// tags
-		tags := map[string]string{}
+		// ad bit per denial type
+		statsRandomAD.addPoints(bp, "randomAD", now)
 
-		// values
-		fields := map[string]interface{}{}
-		for _, rcode := range RCODES {
-			fields[mdns.RcodeToString[rcode]] = statsRandomRcode[rcode]
-		}
+		// negative ttl and soa
+		statsRandomSOA.addPoints(bp, "randomSOA", now)
 
-		// create new point
-		pt, err := client.NewPoint("randomRcodes", tags, fields, now)
-		if err != nil {
-			log.Fatal("Could not create new point. ", err)
-		}
-		if verbose > 2 {
-			log.Printf("Tags:   %v\n", tags)
-			log.Printf("Fields: %v\n", fields)
-		}
+		// aggressive negative caching
+		addAggressivePoints(bp, now)
 
-		// add point to list
-		bp.AddPoint(pt)
+		// per resolver
+		addResolverPoints(bp, "random", now)
 
-		// values
-		fields = map[string]interface{}{
-			"nsec":   statsRandomNSEC,
-			"nsec3":  statsRandomNSEC3,
-			"nonsec": statsRandomNONSEC,
-		}
+		// response times
+		addRttPoints(bp, "random", now)
 
-		// create new point
-		pt, err = client.NewPoint("randomNsec", tags, fields, now)
-		if err != nil {
-			log.Fatal("Could not create new point. ", err)
-		}
-		if verbose > 2 {
-			log.Printf("Tags:   %v\n", tags)
-			log.Printf("Fields: %v\n", fields)
-		}
+		// response sizes and edns
+		addSizePoints(bp, "random", now)
 
-		// add point to list
-		bp.AddPoint(pt)
+		// query name macros
+		addQnamePoints(bp, now)
 
-		// write to database
-		if verbose > 1 {
-			log.Println("Writing to influx")
-		}
-		err = influx.Write(bp)
-		if err != nil {
-			log.Printf("Error writing to InfluxDB: %s", err.Error())
-		}
-	}
+		// errors
+		addErrorPoints(bp, "random", now)
+	})
 }
@@ -0,0 +1,475 @@
+/*
+Copyright © 2020 Ulrich Wisser <ulrich@wisser.se>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"fmt"
+	"html/template"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/DNS-OARC/ripeatlas/measurement"
+	"github.com/DNS-OARC/ripeatlas/measurement/dns"
+	mdns "github.com/miekg/dns"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// reportResponse is the part of a response the report uses
+type reportResponse struct {
+	Role   string
+	PrbID  int
+	Time   time.Time
+	Dst    string
+	Af     int
+	Rcode  string
+	Denial string
+	CD     bool
+}
+
+// reportCounts counts the responses of one row or time bucket
+type reportCounts struct {
+	Total         int
+	Rcode         map[string]int
+	Denial        map[string]int
+	Validating    int
+	Nonvalidating int
+}
+
+// reportRow is one row of a table in the report
+type reportRow struct {
+	Name   string
+	Counts *reportCounts
+}
+
+// reportChart is one chart in the report
+type reportChart struct {
+	Title string
+	SVG   template.HTML
+}
+
+// reportRole is the section of one role in the report
+type reportRole struct {
+	Name   string
+	Counts *reportCounts
+	Charts []reportChart
+}
+
+// report is the data of the html template
+type report struct {
+	Generated time.Time
+	Start     time.Time
+	Stop      time.Time
+	Manifest  *Manifest
+	Roles     []*reportRole
+	Countries []reportRow
+	Servers   []reportRow
+}
+
+var reportRcodes = []string{"NOERROR", "NXDOMAIN", "SERVFAIL"}
+var reportDenials = []string{NSEC, NSEC3, NONSEC}
+
+// reportCmd represents the report command
+var reportCmd = &cobra.Command{
+	Use:   "report [FILE...]",
+	Short: "write a html report of a campaign",
+	Long: `write a html report of a campaign
+
+Results are read from recorded or downloaded files or, without files,
+from the sqlite database. Roles and campaign parameters are taken
+from the campaign manifest.`,
+	Run: runReport,
+}
+
+func init() {
+	rootCmd.AddCommand(reportCmd)
+	reportCmd.Flags().String("db", "", "database file (default is the first sqlite sink)")
+	reportCmd.Flags().StringP("output", "o", "report.html", "output file")
+	reportCmd.Flags().Duration("bucket", time.Hour, "length of one time step in the charts")
+}
+
+func runReport(cmd *cobra.Command, args []string) {
+	var manifest *Manifest
+	roles := make(map[int]string)
+	if len(viper.GetString("manifest")) > 0 {
+		m, err := readManifest(viper.GetString("manifest"))
+		if err != nil {
+			log.Fatal("Could not read manifest. ", err)
+		}
+		manifest = m
+		roles = m.roles()
+	}
+
+	// read responses
+	responses := make([]*reportResponse, 0)
+	add := func(r *reportResponse) { responses = append(responses, r) }
+	if len(args) > 0 {
+		for _, filename := range args {
+			if err := readReportFile(filename, roles, add); err != nil {
+				log.Fatalf("Could not read %s: %s", filename, err)
+			}
+		}
+	} else {
+		file, _ := cmd.Flags().GetString("db")
+		if len(file) == 0 {
+			file = sqliteFile()
+		}
+		if len(file) == 0 {
+			log.Fatal("No files or database given")
+		}
+		if err := readReportDB(file, add); err != nil {
+			log.Fatal("Could not read database. ", err)
+		}
+	}
+	if len(responses) == 0 {
+		log.Fatal("No responses found")
+	}
+	if verbose > 0 {
+		log.Printf("Read %d responses", len(responses))
+	}
+
+	bucket, _ := cmd.Flags().GetDuration("bucket")
+	rep := buildReport(responses, manifest, bucket)
+
+	output, _ := cmd.Flags().GetString("output")
+	f, err := os.Create(output)
+	if err != nil {
+		log.Fatal("Could not create output file. ", err)
+	}
+	defer f.Close()
+	if err = reportTemplate.Execute(f, rep); err != nil {
+		log.Fatal("Could not write report. ", err)
+	}
+}
+
+// readReportFile reads the responses of a result file
+func readReportFile(filename string, roles map[int]string, add func(r *reportResponse)) error {
+	return readResults(filename, func(msm *measurement.Result) {
+		if msm.ParseError != nil || msm.Type() != "dns" {
+			return
+		}
+		role, ok := roles[msm.MsmId()]
+		if !ok {
+			role = "unknown"
+		}
+		eachResult(msm, func(msm *measurement.Result, dst string, result *dns.Result) {
+			msg, err := result.UnpackAbuf()
+			if err != nil {
+				return
+			}
+			add(&reportResponse{
+				Role:   role,
+				PrbID:  msm.PrbId(),
+				Time:   time.Unix(int64(msm.Timestamp()), 0),
+				Dst:    dst,
+				Af:     msm.Af(),
+				Rcode:  mdns.RcodeToString[msg.Rcode],
+				Denial: nsec(msg.Ns),
+				CD:     msg.CheckingDisabled,
+			})
+		})
+	})
+}
+
+// readReportDB reads the responses from the sqlite database
+func readReportDB(file string, add func(r *reportResponse)) error {
+	db, err := openSQLiteReadOnly(file)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT role, prb_id, timestamp, dst, af, rcode, denial, flags FROM responses ORDER BY timestamp")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		r := &reportResponse{}
+		var ts int64
+		var flags string
+		if err = rows.Scan(&r.Role, &r.PrbID, &ts, &r.Dst, &r.Af, &r.Rcode, &r.Denial, &flags); err != nil {
+			return err
+		}
+		r.Time = time.Unix(ts, 0)
+		r.CD = strings.Contains(" "+flags+" ", " cd ")
+		add(r)
+	}
+	return rows.Err()
+}
+
+// buildReport aggregates the responses by role, time, country and server
+// with one time step per bucket
+func buildReport(responses []*reportResponse, manifest *Manifest, step time.Duration) *report {
+	rep := &report{Generated: time.Now().UTC(), Manifest: manifest}
+	bucket := int64(step.Seconds())
+	if bucket < 1 {
+		bucket = 3600
+	}
+
+	// probe metadata for the country table, without a probe
+	// file the unknown probes are fetched in batches
+	probeRefresh.Do(startProbeRefresh)
+	if len(viper.GetString("probefile")) == 0 {
+		accessProbes.Lock()
+		seen := make(map[int]bool)
+		ids := make([]int, 0)
+		for _, r := range responses {
+			if _, ok := probeCache[r.PrbID]; !ok && !seen[r.PrbID] {
+				seen[r.PrbID] = true
+				ids = append(ids, r.PrbID)
+			}
+		}
+		accessProbes.Unlock()
+		if err := fetchProbes(ids); err != nil {
+			log.Printf("Could not get probes: %s", err)
+		}
+	}
+
+	overall := make(map[string]*reportCounts)
+	series := make(map[string]map[int64]*reportCounts)
+	countries := make(map[string]*reportCounts)
+	servers := make(map[string]*reportCounts)
+	var first, last int64
+	for _, r := range responses {
+		ts := r.Time.Unix()
+		if first == 0 || ts < first {
+			first = ts
+		}
+		if ts > last {
+			last = ts
+		}
+
+		if _, ok := overall[r.Role]; !ok {
+			overall[r.Role] = newReportCounts()
+			series[r.Role] = make(map[int64]*reportCounts)
+		}
+		overall[r.Role].add(r)
+
+		b := ts - ts%bucket
+		if _, ok := series[r.Role][b]; !ok {
+			series[r.Role][b] = newReportCounts()
+		}
+		series[r.Role][b].add(r)
+
+		if r.Role == "auth" {
+			key := fmt.Sprintf("%s (IPv%d)", r.Dst, r.Af)
+			if _, ok := servers[key]; !ok {
+				servers[key] = newReportCounts()
+			}
+			servers[key].add(r)
+			continue
+		}
+
+		probe, _ := probeTags(r.PrbID)
+		country := probe.tags()["country"]
+		if _, ok := countries[country]; !ok {
+			countries[country] = newReportCounts()
+		}
+		countries[country].add(r)
+	}
+	rep.Start = time.Unix(first, 0).UTC()
+	rep.Stop = time.Unix(last, 0).UTC()
+
+	// time steps of the charts
+	times := make([]time.Time, 0)
+	steps := make([]int64, 0)
+	for b := first - first%bucket; b <= last; b += bucket {
+		times = append(times, time.Unix(b, 0))
+		steps = append(steps, b)
+	}
+
+	names := make([]string, 0, len(overall))
+	for name := range overall {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		role := &reportRole{Name: name, Counts: overall[name]}
+
+		rcodes := make([]svgSeries, 0)
+		for _, rcode := range append(reportRcodes, "other") {
+			s := svgSeries{Name: rcode}
+			for _, step := range steps {
+				s.Values = append(s.Values, float64(series[name][step].rcode(rcode)))
+			}
+			rcodes = append(rcodes, s)
+		}
+		role.Charts = append(role.Charts, reportChart{Title: "Rcodes", SVG: svgChart(times, rcodes, "")})
+
+		denials := make([]svgSeries, 0)
+		for _, denial := range reportDenials {
+			s := svgSeries{Name: denial}
+			for _, step := range steps {
+				if c := series[name][step]; c != nil {
+					s.Values = append(s.Values, float64(c.Denial[denial]))
+				} else {
+					s.Values = append(s.Values, 0)
+				}
+			}
+			denials = append(denials, s)
+		}
+		role.Charts = append(role.Charts, reportChart{Title: "Denial of existence", SVG: svgChart(times, denials, "")})
+
+		if name == "invalid" {
+			s := svgSeries{Name: "validating"}
+			for _, step := range steps {
+				s.Values = append(s.Values, 100*series[name][step].validationRate())
+			}
+			role.Charts = append(role.Charts, reportChart{Title: "Validating resolvers", SVG: svgChart(times, []svgSeries{s}, "%")})
+		}
+		rep.Roles = append(rep.Roles, role)
+	}
+
+	rep.Countries = reportRows(countries)
+	rep.Servers = reportRows(servers)
+	return rep
+}
+
+// reportRows returns the rows sorted by name
+func reportRows(counts map[string]*reportCounts) []reportRow {
+	rows := make([]reportRow, 0, len(counts))
+	for name, c := range counts {
+		rows = append(rows, reportRow{Name: name, Counts: c})
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].Name < rows[j].Name })
+	return rows
+}
+
+func newReportCounts() *reportCounts {
+	return &reportCounts{Rcode: make(map[string]int), Denial: make(map[string]int)}
+}
+
+// add counts a response, responses to the invalid domain without
+// CD bit show if the resolver validates, see classifyValidation
+func (c *reportCounts) add(r *reportResponse) {
+	c.Total++
+	c.Rcode[r.Rcode]++
+	c.Denial[r.Denial]++
+	if r.Role != "invalid" || r.CD {
+		return
+	}
+	rcode, ok := mdns.StringToRcode[r.Rcode]
+	if !ok {
+		return
+	}
+	switch classifyRcode(rcode) {
+	case VALIDATING:
+		c.Validating++
+	case NONVALIDATING:
+		c.Nonvalidating++
+	}
+}
+
+// rcode returns the count of an rcode, "other" are all rcodes not in reportRcodes
+func (c *reportCounts) rcode(rcode string) int {
+	if c == nil {
+		return 0
+	}
+	if rcode != "other" {
+		return c.Rcode[rcode]
+	}
+	n := c.Total
+	for _, r := range reportRcodes {
+		n -= c.Rcode[r]
+	}
+	return n
+}
+
+// validationRate is the share of validating responses
+func (c *reportCounts) validationRate() float64 {
+	if c == nil || c.Validating+c.Nonvalidating == 0 {
+		return 0
+	}
+	return float64(c.Validating) / float64(c.Validating+c.Nonvalidating)
+}
+
+// Percent formats n as share of the total
+func (c *reportCounts) Percent(n int) string {
+	if c.Total == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%%", 100*float64(n)/float64(c.Total))
+}
+
+// Validation formats the validation rate
+func (c *reportCounts) Validation() string {
+	if c.Validating+c.Nonvalidating == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%%", 100*c.validationRate())
+}
+
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>nsecmonitor report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 1.5em; }
+th, td { border: 1px solid #ccc; padding: 3px 8px; text-align: right; }
+th:first-child, td:first-child { text-align: left; }
+h3 { margin-bottom: 0.2em; }
+</style>
+</head>
+<body>
+<h1>nsecmonitor report</h1>
+<p>Responses from {{.Start.Format "2006-01-02 15:04"}} to {{.Stop.Format "2006-01-02 15:04"}} UTC, generated {{.Generated.Format "2006-01-02 15:04"}} UTC.</p>
+
+{{with .Manifest}}
+<h2>Campaign</h2>
+<table>
+<tr><th>start</th><td>{{.Start.Format "2006-01-02 15:04:05 MST"}}</td></tr>
+<tr><th>stop</th><td>{{.Stop.Format "2006-01-02 15:04:05 MST"}}</td></tr>
+{{range $k, $v := .Parameters}}<tr><th>{{$k}}</th><td>{{$v}}</td></tr>
+{{end}}</table>
+<table>
+<tr><th>id</th><th>role</th><th>description</th><th>af</th><th>target</th><th>query</th><th>cd</th><th>payload</th><th>interval</th></tr>
+{{range .Measurements}}<tr><td>{{.ID}}</td><td>{{.Role}}</td><td>{{.Description}}</td><td>{{.AF}}</td><td>{{.Target}}</td><td>{{.QueryArgument}}</td><td>{{.CD}}</td><td>{{.PayloadSize}}</td><td>{{.Interval}}</td></tr>
+{{end}}</table>
+{{end}}
+
+<h2>Roles</h2>
+<table>
+<tr><th>role</th><th>responses</th><th>NOERROR</th><th>NXDOMAIN</th><th>SERVFAIL</th><th>NSEC</th><th>NSEC3</th><th>no denial</th><th>validating</th></tr>
+{{range .Roles}}{{template "counts" .}}{{end}}</table>
+{{range .Roles}}
+<h3>{{.Name}}</h3>
+{{range .Charts}}<h4>{{.Title}}</h4>
+{{.SVG}}
+{{end}}{{end}}
+
+<h2>Countries</h2>
+<table>
+<tr><th>country</th><th>responses</th><th>NOERROR</th><th>NXDOMAIN</th><th>SERVFAIL</th><th>NSEC</th><th>NSEC3</th><th>no denial</th><th>validating</th></tr>
+{{range .Countries}}{{template "counts" .}}{{end}}</table>
+
+{{if .Servers}}
+<h2>Authoritative servers</h2>
+<table>
+<tr><th>server</th><th>responses</th><th>NOERROR</th><th>NXDOMAIN</th><th>SERVFAIL</th><th>NSEC</th><th>NSEC3</th><th>no denial</th><th>validating</th></tr>
+{{range .Servers}}{{template "counts" .}}{{end}}</table>
+{{end}}
+</body>
+</html>
+{{define "counts"}}<tr><td>{{.Name}}</td>{{with .Counts}}<td>{{.Total}}</td><td>{{.Percent (index .Rcode "NOERROR")}}</td><td>{{.Percent (index .Rcode "NXDOMAIN")}}</td><td>{{.Percent (index .Rcode "SERVFAIL")}}</td><td>{{.Percent (index .Denial "NSEC")}}</td><td>{{.Percent (index .Denial "NSEC3")}}</td><td>{{.Percent (index .Denial "NONSEC")}}</td><td>{{.Validation}}</td>{{end}}</tr>
+{{end}}`))
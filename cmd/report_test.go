@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"testing"
+
+	mdns "github.com/miekg/dns"
+)
+
+func TestReportValidation(t *testing.T) {
+	c := newReportCounts()
+	for _, r := range []*reportResponse{
+		{Role: "invalid", Rcode: "SERVFAIL"},
+		{Role: "invalid", Rcode: "NOERROR"},
+		{Role: "invalid", Rcode: "NXDOMAIN"},
+		{Role: "invalid", Rcode: "REFUSED"},
+		{Role: "invalid", Rcode: "SERVFAIL", CD: true},
+		{Role: "invalid", Rcode: "bogus"},
+		{Role: "static", Rcode: "SERVFAIL"},
+	} {
+		c.add(r)
+	}
+	if c.Validating != 1 || c.Nonvalidating != 2 {
+		t.Errorf("got %d validating and %d nonvalidating", c.Validating, c.Nonvalidating)
+	}
+
+	// the same as the live statistics
+	for rcode, name := range mdns.RcodeToString {
+		c := newReportCounts()
+		c.add(&reportResponse{Role: "invalid", Rcode: name})
+		want := classifyValidation(&mdns.Msg{MsgHdr: mdns.MsgHdr{Rcode: rcode}})
+		got := ""
+		if c.Validating > 0 {
+			got = VALIDATING
+		}
+		if c.Nonvalidating > 0 {
+			got = NONVALIDATING
+		}
+		if got != want {
+			t.Errorf("%s: report %q, live %q", name, got, want)
+		}
+	}
+}
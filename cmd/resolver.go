@@ -0,0 +1,212 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	mdns "github.com/miekg/dns"
+	"github.com/spf13/viper"
+)
+
+// resolverKey identifies a resolver used by the probes, private
+// resolvers are identified by the probe and address family
+type resolverKey struct {
+	Resolver string
+	PrbID    int
+	Af       int
+}
+
+// resolverGroup is the resolver and ASN the points are tagged with
+type resolverGroup struct {
+	Resolver string
+	ASN      int
+}
+
+// ASNRETRY is how long a failed ASN lookup is not retried
+const ASNRETRY = time.Hour
+
+var statsResolver = make(map[string]map[resolverKey]*rcodeCount)
+var resolverGroups = make(map[resolverKey]resolverGroup)
+var accessResolver = sync.Mutex{}
+
+var resolverASN = make(map[string]int)
+var resolverPending = make(map[string]bool)
+var resolverFailed = make(map[string]time.Time)
+var accessResolverASN = sync.Mutex{}
+
+// cgnat is the shared address space of RFC 6598
+var _, cgnat, _ = net.ParseCIDR("100.64.0.0/10")
+
+// addResolver counts the response for the resolver that sent it.
+// Private resolver addresses are not used, they are bucketed per
+// probe ASN instead. ASNs are looked up when the points are added.
+func addResolver(role string, prbid int, af int, dst string, msg *mdns.Msg) {
+	key := resolverKey{Resolver: resolverName(dst)}
+	if key.Resolver == "private" {
+		key.PrbID = prbid
+		key.Af = af
+	}
+
+	accessResolver.Lock()
+	defer accessResolver.Unlock()
+
+	if _, ok := statsResolver[role]; !ok {
+		statsResolver[role] = make(map[resolverKey]*rcodeCount)
+	}
+	c, ok := statsResolver[role][key]
+	if !ok {
+		c = &rcodeCount{}
+		statsResolver[role][key] = c
+	}
+	c.add(msg)
+}
+
+// resolverName returns the address of a public resolver, "private" for
+// private, loopback, link local and shared addresses or "unknown"
+func resolverName(dst string) string {
+	ip := net.ParseIP(dst)
+	switch {
+	case ip == nil:
+		return "unknown"
+	case ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() || cgnat.Contains(ip):
+		return "private"
+	}
+	return ip.String()
+}
+
+// asn returns the ASN of a public resolver or of the probe for private
+// resolvers, false if it is not known yet
+func (k resolverKey) asn() (int, bool) {
+	switch k.Resolver {
+	case "unknown":
+		return 0, true
+	case "private":
+		probe, ok := probeTags(k.PrbID)
+		if k.Af == 6 {
+			return probe.ASNv6, ok
+		}
+		return probe.ASNv4, ok
+	}
+	return lookupASN(net.ParseIP(k.Resolver))
+}
+
+// addResolverPoints adds rcodes and denial types per resolver. The ASN of
+// a resolver is fixed once it is known, resolvers are left out until then.
+func addResolverPoints(bp *Batch, role string, now time.Time) {
+	accessResolver.Lock()
+	group := make(map[resolverGroup]*rcodeCount)
+	for key, c := range statsResolver[role] {
+		g, ok := resolverGroups[key]
+		if !ok {
+			asn, known := key.asn()
+			if !known {
+				continue
+			}
+			g = resolverGroup{Resolver: key.Resolver, ASN: asn}
+			resolverGroups[key] = g
+		}
+		if _, ok := group[g]; !ok {
+			group[g] = &rcodeCount{}
+		}
+		group[g].merge(c)
+	}
+	accessResolver.Unlock()
+
+	for key, c := range group {
+		tags := map[string]string{
+			"role":         role,
+			"resolver":     key.Resolver,
+			"resolver_asn": "unknown",
+		}
+		if key.ASN > 0 {
+			tags["resolver_asn"] = strconv.Itoa(key.ASN)
+		}
+		addPoint(bp, "resolverRcodes", tags, c.rcodeFields(), now)
+		addPoint(bp, "resolverNsec", tags, c.nsecFields(), now)
+	}
+}
+
+// lookupASN returns the origin ASN of the address, false if it is not known
+// yet. Lookups are only done if "resolverasn" is set and run in the background,
+// failed lookups return 0 and are retried after ASNRETRY.
+func lookupASN(ip net.IP) (int, bool) {
+	if !viper.GetBool("resolverasn") {
+		return 0, true
+	}
+
+	accessResolverASN.Lock()
+	defer accessResolverASN.Unlock()
+
+	if asn, ok := resolverASN[ip.String()]; ok {
+		return asn, true
+	}
+	failed, ok := resolverFailed[ip.String()]
+	if ok && time.Since(failed) < ASNRETRY {
+		return 0, true
+	}
+	if !resolverPending[ip.String()] {
+		resolverPending[ip.String()] = true
+		go fetchASN(ip)
+	}
+	return 0, ok
+}
+
+func fetchASN(ip net.IP) {
+	asn, err := queryASN(ip)
+
+	accessResolverASN.Lock()
+	defer accessResolverASN.Unlock()
+	delete(resolverPending, ip.String())
+	if err != nil {
+		log.Printf("Could not get ASN of %s: %s", ip, err)
+		resolverFailed[ip.String()] = time.Now()
+		return
+	}
+	delete(resolverFailed, ip.String())
+	resolverASN[ip.String()] = asn
+}
+
+// queryASN looks up the origin ASN with the Team Cymru DNS service
+func queryASN(ip net.IP) (int, error) {
+	var qname string
+	if ip4 := ip.To4(); ip4 != nil {
+		qname = fmt.Sprintf("%d.%d.%d.%d.origin.asn.cymru.com.", ip4[3], ip4[2], ip4[1], ip4[0])
+	} else {
+		arpa, err := mdns.ReverseAddr(ip.String())
+		if err != nil {
+			return 0, err
+		}
+		qname = strings.TrimSuffix(arpa, "ip6.arpa.") + "origin6.asn.cymru.com."
+	}
+
+	conf, err := mdns.ClientConfigFromFile("/etc/resolv.conf")
+	if err != nil {
+		return 0, err
+	}
+	if len(conf.Servers) == 0 {
+		return 0, fmt.Errorf("no resolver configured")
+	}
+	m := new(mdns.Msg)
+	m.SetQuestion(qname, mdns.TypeTXT)
+	c := new(mdns.Client)
+	r, _, err := c.Exchange(m, net.JoinHostPort(conf.Servers[0], conf.Port))
+	if err != nil {
+		return 0, err
+	}
+
+	// "3320 | 193.0.0.0/21 | DE | ripencc | 1993-09-01"
+	for _, rr := range r.Answer {
+		if txt, ok := rr.(*mdns.TXT); ok && len(txt.Txt) > 0 {
+			fields := strings.Fields(strings.Split(txt.Txt[0], "|")[0])
+			if len(fields) > 0 {
+				return strconv.Atoi(fields[0])
+			}
+		}
+	}
+	return 0, nil
+}
@@ -0,0 +1,124 @@
+package cmd
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DNS-OARC/ripeatlas/measurement"
+	"github.com/DNS-OARC/ripeatlas/measurement/dns"
+	mdns "github.com/miekg/dns"
+)
+
+// Response is one DNS response as kept by the response stores
+type Response struct {
+	MsmID      int
+	Role       string
+	PrbID      int
+	Timestamp  time.Time
+	Dst        string
+	Af         int
+	Rcode      string
+	Denial     string
+	Flags      string
+	NegTTL     int64
+	SOAMinimum int64
+	Rt         float64
+	Size       int
+	Abuf       string
+}
+
+// ResponseStore is a sink that also keeps every single response
+type ResponseStore interface {
+	WriteResponses(responses []*Response) error
+}
+
+var pendingResponses = make([]*Response, 0)
+var accessResponses = sync.Mutex{}
+
+// newResponse collects the data of one response, ttls are -1 if
+// there is no SOA in the authority section
+func newResponse(role string, msm *measurement.Result, dst string, result *dns.Result, msg *mdns.Msg) *Response {
+	r := &Response{
+		MsmID:      msm.MsmId(),
+		Role:       role,
+		PrbID:      msm.PrbId(),
+		Timestamp:  time.Unix(int64(msm.Timestamp()), 0),
+		Dst:        dst,
+		Af:         msm.Af(),
+		Rcode:      mdns.RcodeToString[msg.Rcode],
+		Denial:     nsec(msg.Ns),
+		Flags:      flags(msg),
+		NegTTL:     -1,
+		SOAMinimum: -1,
+		Rt:         result.Rt(),
+		Size:       result.Size(),
+		Abuf:       result.Abuf(),
+	}
+	if s := soa(msg.Ns); s != nil {
+		r.NegTTL = int64(s.Hdr.Ttl)
+		r.SOAMinimum = int64(s.Minttl)
+	}
+	return r
+}
+
+// storeResponse queues a response for all response stores, it is
+// written with the next stats snapshot
+func storeResponse(role string, msm *measurement.Result, dst string, result *dns.Result, msg *mdns.Msg) {
+	if !hasResponseStores() {
+		return
+	}
+	r := newResponse(role, msm, dst, result, msg)
+
+	accessResponses.Lock()
+	defer accessResponses.Unlock()
+	pendingResponses = append(pendingResponses, r)
+}
+
+// hasResponseStores checks if any sink keeps single responses
+func hasResponseStores() bool {
+	sinksInit.Do(loadSinks)
+	for _, s := range sinks {
+		if _, ok := s.sink.(ResponseStore); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// takeResponses returns and clears the queued responses
+func takeResponses() []*Response {
+	accessResponses.Lock()
+	defer accessResponses.Unlock()
+
+	responses := pendingResponses
+	pendingResponses = make([]*Response, 0)
+	return responses
+}
+
+// flags returns the header flags of the response, e.g. "qr rd ra ad"
+func flags(msg *mdns.Msg) string {
+	f := make([]string, 0)
+	if msg.Response {
+		f = append(f, "qr")
+	}
+	if msg.Authoritative {
+		f = append(f, "aa")
+	}
+	if msg.Truncated {
+		f = append(f, "tc")
+	}
+	if msg.RecursionDesired {
+		f = append(f, "rd")
+	}
+	if msg.RecursionAvailable {
+		f = append(f, "ra")
+	}
+	if msg.AuthenticatedData {
+		f = append(f, "ad")
+	}
+	if msg.CheckingDisabled {
+		f = append(f, "cd")
+	}
+	return strings.Join(f, " ")
+}
@@ -0,0 +1,111 @@
+package cmd
+
+import (
+	"bufio"
+	"compress/bzip2"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/DNS-OARC/ripeatlas/measurement"
+	"github.com/spf13/viper"
+)
+
+var recordFile *os.File
+var recordInit = sync.Once{}
+var accessRecord = sync.Mutex{}
+
+// openRecord opens the "record" file for appending
+func openRecord() {
+	if len(viper.GetString("record")) == 0 {
+		return
+	}
+	f, err := os.OpenFile(viper.GetString("record"), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		log.Fatal("Could not open record file. ", err)
+	}
+	recordFile = f
+}
+
+// record appends a raw result as one line to the record file
+func record(raw []byte) {
+	recordInit.Do(openRecord)
+	if recordFile == nil {
+		return
+	}
+
+	accessRecord.Lock()
+	defer accessRecord.Unlock()
+	if _, err := recordFile.Write(append(raw, '\n')); err != nil {
+		log.Printf("Could not record result: %s", err)
+	}
+}
+
+// readResults calls fn for every result in a file. Files are recorded
+// JSON lines or Ripe Atlas downloads (a JSON list), plain or compressed
+// with bzip2 or gzip.
+func readResults(filename string, fn func(msm *measurement.Result)) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	switch {
+	case strings.HasSuffix(filename, ".bz2"):
+		r = bzip2.NewReader(f)
+	case strings.HasSuffix(filename, ".gz"):
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	br := bufio.NewReader(r)
+	d := json.NewDecoder(br)
+
+	// downloads are one list, records one result per line
+	list := false
+	for {
+		b, err := br.Peek(1)
+		if err != nil {
+			break
+		}
+		if strings.TrimSpace(string(b)) == "" {
+			br.ReadByte()
+			continue
+		}
+		list = b[0] == '['
+		break
+	}
+	if list {
+		if _, err = d.Token(); err != nil {
+			return err
+		}
+	}
+
+	for d.More() {
+		var raw json.RawMessage
+		if err = d.Decode(&raw); err != nil {
+			return err
+		}
+		fn(parseResult(raw))
+	}
+	return nil
+}
+
+// parseResult parses one raw result, errors end up in ParseError
+func parseResult(raw []byte) *measurement.Result {
+	msm := &measurement.Result{}
+	if err := json.Unmarshal(raw, msm); err != nil {
+		msm.ParseError = err
+	}
+	return msm
+}
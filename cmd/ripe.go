@@ -1,12 +1,14 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
 
 	"github.com/DNS-OARC/ripeatlas/measurement"
 	"github.com/graarh/golang-socketio"
 	"github.com/graarh/golang-socketio/transport"
+	"github.com/spf13/viper"
 )
 
 const (
@@ -16,6 +18,11 @@ const (
 func subscribe(measurements []int) <-chan *measurement.Result {
 	ch := make(chan *measurement.Result, 1000)
 
+	// one window per measurement round
+	if viper.GetBool("rounds") {
+		alignRounds(measurements)
+	}
+
 	c, err := gosocketio.Dial(StreamUrl, transport.GetDefaultWebsocketTransport())
 	if err != nil {
 		log.Fatalf("gosocketio.Dial(%s): %s", StreamUrl, err.Error())
@@ -31,8 +38,9 @@ func subscribe(measurements []int) <-chan *measurement.Result {
 		log.Fatalf("c.On(atlas_error): %s", err.Error())
 	}
 
-	err = c.On("atlas_result", func(h *gosocketio.Channel, r measurement.Result) {
-		ch <- &r
+	err = c.On("atlas_result", func(h *gosocketio.Channel, raw json.RawMessage) {
+		record(raw)
+		ch <- parseResult(raw)
 	})
 	if err != nil {
 		log.Fatalf("c.On(atlas_result): %s", err.Error())
@@ -70,6 +70,19 @@ func init() {
 
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.nsecmonitor)")
 	rootCmd.PersistentFlags().CountVarP(&verbose, "verbose", "v", "repeat for more verbose printouts")
+	rootCmd.PersistentFlags().String("probefile", "", "probe archive dump to read probe metadata from")
+	rootCmd.PersistentFlags().Duration("proberefresh", 24*time.Hour, "how often probe metadata is refreshed (0=never)")
+	rootCmd.PersistentFlags().Bool("resolverasn", false, "look up the origin ASN of public resolvers")
+	rootCmd.PersistentFlags().Bool("rttbyserver", false, "response times per server")
+	rootCmd.PersistentFlags().Bool("rttbycountry", false, "response times per probe country")
+	rootCmd.PersistentFlags().Duration("window", time.Minute, "results are aggregated in windows of this length by their timestamp")
+	rootCmd.PersistentFlags().Duration("lateness", 5*time.Minute, "how long to wait for late results before a window is closed")
+	rootCmd.PersistentFlags().Bool("rounds", false, "align windows to the interval and start time of the measurements")
+	rootCmd.PersistentFlags().String("record", "", "append all received results to this file, one per line")
+	rootCmd.PersistentFlags().String("manifest", "", "campaign manifest file")
+	rootCmd.PersistentFlags().String("bufferdir", "", "directory to keep batches that could not be written to a sink")
+	rootCmd.PersistentFlags().Int64("buffersize", 1<<30, "maximum size of the buffer per sink in bytes (0=no limit)")
+	viper.BindPFlags(rootCmd.PersistentFlags())
 
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
@@ -0,0 +1,178 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// measurementInfo is the part of the Ripe Atlas measurement we need
+// to align windows with the rounds of a measurement
+type measurementInfo struct {
+	ID           int `json:"id"`
+	Interval     int `json:"interval"`
+	StartTime    int `json:"start_time"`
+	Participants int `json:"participant_count"`
+}
+
+// ROUNDHISTORY is the number of rounds used to guess the participating
+// probes if the API does not tell
+const ROUNDHISTORY = 3
+
+var roundMeasurements = make(map[int]*measurementInfo)
+var roundProbes = make(map[int][]map[int]bool)
+var accessRounds = sync.Mutex{}
+
+// alignRounds sets the windows to the interval and start time of the
+// measurements so every window is one measurement round. All measurements
+// must have the same interval and start at the same offset into it.
+func alignRounds(measurements []int) {
+	if len(measurements) == 0 {
+		return
+	}
+	for _, id := range measurements {
+		info, err := loadMeasurement(id)
+		if err != nil {
+			log.Fatalf("Could not get measurement %d: %s", id, err)
+		}
+		roundMeasurements[id] = info
+	}
+
+	first := roundMeasurements[measurements[0]]
+	if first.Interval <= 0 {
+		log.Fatalf("Measurement %d has no interval", first.ID)
+	}
+	for _, id := range measurements {
+		m := roundMeasurements[id]
+		if m.Interval != first.Interval {
+			log.Fatalf("Measurement %d has interval %ds, measurement %d has %ds, rounds can only be aligned for equal intervals",
+				id, m.Interval, first.ID, first.Interval)
+		}
+		if m.StartTime%m.Interval != first.StartTime%first.Interval {
+			log.Fatalf("Rounds of measurement %d start %ds into the interval, of measurement %d %ds, rounds can only be aligned for equal offsets",
+				id, m.StartTime%m.Interval, first.ID, first.StartTime%first.Interval)
+		}
+	}
+	viper.Set("window", time.Duration(first.Interval)*time.Second)
+	windowOffset = int64(first.StartTime % first.Interval)
+	if verbose > 0 {
+		log.Printf("Windows aligned to rounds of %ds starting at %s", first.Interval, time.Unix(int64(first.StartTime), 0))
+	}
+
+	// participants change while the measurement runs
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		for range ticker.C {
+			for _, id := range measurements {
+				info, err := loadMeasurement(id)
+				if err != nil {
+					log.Printf("Could not get measurement %d: %s", id, err)
+					continue
+				}
+				accessRounds.Lock()
+				roundMeasurements[id] = info
+				accessRounds.Unlock()
+			}
+		}
+	}()
+}
+
+// loadMeasurement gets the measurement from the Ripe Atlas API
+func loadMeasurement(id int) (*measurementInfo, error) {
+	client := &http.Client{Timeout: 20 * time.Second}
+
+	resp, err := client.Get(fmt.Sprintf("https://atlas.ripe.net/api/v2/measurements/%d/", id))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("API call returned status %s", resp.Status)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &measurementInfo{}
+	if err = json.Unmarshal(body, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// addRoundPoints adds the completeness of the round for every measurement.
+// Participants is the participant count from the API or, if that is unknown,
+// the number of probes that reported in the last ROUNDHISTORY rounds.
+func addRoundPoints(bp *Batch, w *window) {
+	accessRounds.Lock()
+	defer accessRounds.Unlock()
+
+	if len(roundMeasurements) == 0 {
+		return
+	}
+
+	responses := make(map[int]int)
+	probes := make(map[int]map[int]bool)
+	for _, r := range w.results {
+		id := r.msm.MsmId()
+		if r.msm.DnsResult() != nil {
+			responses[id]++
+		}
+		for _, s := range r.msm.DnsResultsets() {
+			if s.Result() != nil {
+				responses[id]++
+			}
+		}
+		if _, ok := probes[id]; !ok {
+			probes[id] = make(map[int]bool)
+		}
+		probes[id][r.msm.PrbId()] = true
+	}
+
+	ids := make([]int, 0, len(roundMeasurements))
+	for id := range roundMeasurements {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		// remember the probes of the last rounds
+		history := append(roundProbes[id], probes[id])
+		if len(history) > ROUNDHISTORY {
+			history = history[len(history)-ROUNDHISTORY:]
+		}
+		roundProbes[id] = history
+
+		participants := roundMeasurements[id].Participants
+		if participants <= 0 {
+			seen := make(map[int]bool)
+			for _, round := range history {
+				for prbid := range round {
+					seen[prbid] = true
+				}
+			}
+			participants = len(seen)
+		}
+
+		// values
+		fields := map[string]interface{}{
+			"responses":    responses[id],
+			"probes":       len(probes[id]),
+			"participants": participants,
+		}
+		if participants > 0 {
+			fields["completeness"] = float64(len(probes[id])) / float64(participants)
+		}
+		addPoint(bp, "round", map[string]string{"msm": strconv.Itoa(id)}, fields, w.start)
+	}
+}
@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// bucket bounds for response times in milliseconds
+var rttBounds = []float64{5, 10, 20, 50, 100, 200, 500, 1000, 2000, 5000}
+
+// rttKey identifies a response time distribution, server and probe
+// are only set if "rttbyserver" and "rttbycountry" are given
+type rttKey struct {
+	Server string
+	PrbID  int
+}
+
+// rttGroup is a distribution of the probes of one country
+type rttGroup struct {
+	Server  string
+	Country string
+}
+
+var statsRtt = make(map[string]map[rttKey]*distribution)
+var accessRtt = sync.Mutex{}
+
+// addRtt records the response time of a result. Resolvers are
+// bucketed like the resolver statistics.
+func addRtt(role string, prbid int, af int, server string, rt float64) {
+	observeRtt(role, server, af, rt)
+
+	key := rttKey{}
+	if viper.GetBool("rttbyserver") {
+		key.Server = server
+		if role != "auth" {
+			key.Server = resolverName(server)
+		}
+	}
+	if viper.GetBool("rttbycountry") {
+		// the country is looked up when the points are added
+		key.PrbID = prbid
+	}
+
+	accessRtt.Lock()
+	if _, ok := statsRtt[role]; !ok {
+		statsRtt[role] = make(map[rttKey]*distribution)
+	}
+	d, ok := statsRtt[role][key]
+	if !ok {
+		d = newHistogram(rttBounds)
+		statsRtt[role][key] = d
+	}
+	accessRtt.Unlock()
+
+	d.add(rt)
+}
+
+// addRttPoints adds the response time quantiles and histogram of the last
+// interval, the distributions start over after each call
+func addRttPoints(bp *Batch, role string, now time.Time) {
+	accessRtt.Lock()
+	defer accessRtt.Unlock()
+
+	// group the probes by country
+	group := make(map[rttGroup]*distribution)
+	for key, d := range statsRtt[role] {
+		g := rttGroup{Server: key.Server}
+		if key.PrbID > 0 {
+			probe, ok := probeTags(key.PrbID)
+			if !ok {
+				// kept until the country is known
+				continue
+			}
+			g.Country = probe.tags()["country"]
+		}
+		if _, ok := group[g]; !ok {
+			group[g] = newHistogram(rttBounds)
+		}
+		for _, v := range d.drain() {
+			group[g].add(v)
+		}
+	}
+
+	for key, d := range group {
+		tags := map[string]string{"role": role}
+		if len(key.Server) > 0 {
+			tags["server"] = key.Server
+		}
+		if len(key.Country) > 0 {
+			tags["country"] = key.Country
+		}
+		addPoint(bp, "rtt", tags, d.fields(""), now)
+	}
+}
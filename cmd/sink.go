@@ -0,0 +1,197 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Point is one measurement written to the sinks
+type Point struct {
+	Name   string                 `json:"name"`
+	Tags   map[string]string      `json:"tags"`
+	Fields map[string]interface{} `json:"fields"`
+	Time   time.Time              `json:"time"`
+}
+
+// Batch collects the points of one stats snapshot
+type Batch struct {
+	Points []*Point
+}
+
+// Sink writes points to a storage backend
+type Sink interface {
+	Write(points []*Point) error
+	Close() error
+}
+
+// sinkTypes maps the type of a sink in the config file to its constructor
+var sinkTypes = map[string]func(name string, conf *viper.Viper) (Sink, error){}
+
+// namedSink is a configured sink
+type namedSink struct {
+	name   string
+	sink   Sink
+	buffer *sinkBuffer
+}
+
+var sinks []namedSink
+var sinksInit = sync.Once{}
+
+// loadSinks creates all sinks from the "sinks" section of the config file
+//
+//	sinks:
+//	  - name: influx
+//	    type: influx1
+//	    server: http://localhost:8086
+//	    database: nsecmonitor
+//
+// Without a "sinks" section the influx1 sink is created from influxserver,
+// influxdb, influxuser and influxpasswd.
+//
+// With "bufferdir" set, batches that could not be written are kept in
+// a subdirectory per sink and written once the sink is back.
+func loadSinks() {
+	confs := make([]map[string]interface{}, 0)
+	if err := viper.UnmarshalKey("sinks", &confs); err != nil {
+		log.Fatal("Could not read sinks. ", err)
+	}
+
+	if len(confs) == 0 {
+		confs = append(confs, map[string]interface{}{
+			"name":     "influx",
+			"type":     "influx1",
+			"server":   viper.GetString("influxserver"),
+			"database": viper.GetString("influxdb"),
+			"user":     viper.GetString("influxuser"),
+			"password": viper.GetString("influxpasswd"),
+		})
+	}
+
+	names := make(map[string]bool)
+	for i, c := range confs {
+		conf := viper.New()
+		if err := conf.MergeConfigMap(c); err != nil {
+			log.Fatalf("Sink %d: %s", i, err)
+		}
+		name := conf.GetString("name")
+		if len(name) == 0 {
+			name = fmt.Sprintf("%s#%d", conf.GetString("type"), i)
+		}
+		if names[name] {
+			log.Fatalf("Sink %s is configured twice", name)
+		}
+		names[name] = true
+
+		newSink, ok := sinkTypes[conf.GetString("type")]
+		if !ok {
+			log.Fatalf("Sink %s has unknown type %s", name, conf.GetString("type"))
+		}
+		s, err := newSink(name, conf)
+		if err != nil {
+			log.Fatalf("Sink %s: %s", name, err)
+		}
+		ns := namedSink{name: name, sink: s}
+		if len(viper.GetString("bufferdir")) > 0 {
+			ns.buffer, err = newSinkBuffer(filepath.Join(viper.GetString("bufferdir"), name), viper.GetInt64("buffersize"))
+			if err != nil {
+				log.Fatalf("Sink %s buffer: %s", name, err)
+			}
+		}
+		sinks = append(sinks, ns)
+	}
+	if verbose > 0 {
+		log.Printf("Writing to %d sinks", len(sinks))
+	}
+}
+
+// checkSinkConf checks that there is somewhere to write to
+func checkSinkConf() {
+	if viper.IsSet("sinks") {
+		return
+	}
+	if len(viper.GetString("influxserver")) == 0 {
+		log.Println("Influx server must be given")
+	}
+	if len(viper.GetString("influxdb")) == 0 {
+		log.Println("Influx database must be given")
+	}
+}
+
+// writeSinks writes the batch and the queued responses to all sinks.
+// If a sink has a buffer, failed batches are buffered and older
+// batches are written first.
+func writeSinks(bp *Batch) {
+	sinksInit.Do(loadSinks)
+
+	responses := takeResponses()
+	for _, s := range sinks {
+		e := &bufferEntry{Points: bp.Points}
+		if _, ok := s.sink.(ResponseStore); ok {
+			e.Responses = responses
+		}
+		if verbose > 1 {
+			log.Printf("Writing %d points and %d responses to %s", len(e.Points), len(e.Responses), s.name)
+		}
+
+		if s.buffer == nil {
+			if err := writeEntry(s.sink, e); err != nil {
+				log.Printf("Error writing to %s: %s", s.name, err.Error())
+			}
+			continue
+		}
+
+		if s.buffer.empty() {
+			err := writeEntry(s.sink, e)
+			if err == nil {
+				continue
+			}
+			log.Printf("Error writing to %s, buffering: %s", s.name, err.Error())
+			if err = s.buffer.push(e); err != nil {
+				log.Printf("Could not buffer batch for %s: %s", s.name, err.Error())
+			}
+			continue
+		}
+
+		// keep the order, the new batch goes after the buffered ones
+		if err := s.buffer.push(e); err != nil {
+			log.Printf("Could not buffer batch for %s: %s", s.name, err.Error())
+		}
+		if err := s.buffer.replay(s.sink); err != nil {
+			log.Printf("Error writing buffered batches to %s: %s", s.name, err.Error())
+		}
+	}
+}
+
+// saveStats handles the results of every closed window, calls snapshot,
+// evaluates the alert rules and writes the points to all sinks. Points
+// are stamped with the start of the window, the counters are not reset
+// and include all windows handled so far.
+func saveStats(snapshot func(bp *Batch, now time.Time)) {
+	sinksInit.Do(loadSinks)
+
+	ticker := time.NewTicker(UPDATEINTERVAL)
+	for {
+		for _, w := range closeWindows(<-ticker.C) {
+			w.handle()
+
+			bp := &Batch{Points: make([]*Point, 0)}
+			snapshot(bp, w.start)
+
+			// windows, rounds and sink buffers
+			addWindowPoints(bp, w.start)
+			addRoundPoints(bp, w)
+			addBufferPoints(bp, w.start)
+
+			// alert rules
+			evaluateAlerts(bp, w.start)
+
+			// write to sinks
+			writeSinks(bp)
+		}
+	}
+}
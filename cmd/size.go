@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	mdns "github.com/miekg/dns"
+)
+
+// bucket bounds for response sizes in bytes
+var sizeBounds = []float64{512, 1232, 1452, 4096}
+
+// sizeKey identifies the responses of one measurement
+type sizeKey struct {
+	Role  string
+	MsmID int
+}
+
+// sizeCount holds size, truncation and EDNS statistics
+type sizeCount struct {
+	Size      *distribution
+	Truncated int
+	NoEdns    int
+	Version   map[uint8]int
+	Buffer    map[uint16]int
+}
+
+var statsSize = make(map[sizeKey]*sizeCount)
+var accessSize = sync.Mutex{}
+
+// addSize records size, TC bit and EDNS parameters of the response
+func addSize(role string, msmid int, size int, msg *mdns.Msg) {
+	key := sizeKey{Role: role, MsmID: msmid}
+
+	accessSize.Lock()
+	defer accessSize.Unlock()
+
+	c, ok := statsSize[key]
+	if !ok {
+		c = &sizeCount{Size: newHistogram(sizeBounds), Version: make(map[uint8]int), Buffer: make(map[uint16]int)}
+		statsSize[key] = c
+	}
+	c.Size.add(float64(size))
+	if msg.Truncated {
+		c.Truncated++
+	}
+	opt := msg.IsEdns0()
+	if opt == nil {
+		c.NoEdns++
+		return
+	}
+	c.Version[opt.Version()]++
+	c.Buffer[opt.UDPSize()]++
+}
+
+// addSizePoints adds one <role>Size point per measurement with size
+// quantiles and counts for truncation, EDNS versions and advertised buffer sizes
+func addSizePoints(bp *Batch, role string, now time.Time) {
+	accessSize.Lock()
+	defer accessSize.Unlock()
+
+	for key, c := range statsSize {
+		if key.Role != role {
+			continue
+		}
+		tags := map[string]string{"msm_id": strconv.Itoa(key.MsmID)}
+
+		// values
+		fields := c.Size.fields("size_")
+		fields["truncated"] = c.Truncated
+		fields["noedns"] = c.NoEdns
+		for version, count := range c.Version {
+			fields["edns"+strconv.Itoa(int(version))] = count
+		}
+		for buffer, count := range c.Buffer {
+			fields["buffer_"+strconv.Itoa(int(buffer))] = count
+		}
+		addPoint(bp, role+"Size", tags, fields, now)
+	}
+}
@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	mdns "github.com/miekg/dns"
+)
+
+// soaStats collects the SOA records from the authority section of
+// negative answers, per measurement
+type soaStats struct {
+	access      sync.Mutex
+	ttl         map[int]*distribution
+	minttl      map[int]*distribution
+	serial      map[int]map[uint32]int
+	aboveMinttl map[int]int
+}
+
+func newSOAStats() *soaStats {
+	return &soaStats{
+		ttl:         make(map[int]*distribution),
+		minttl:      make(map[int]*distribution),
+		serial:      make(map[int]map[uint32]int),
+		aboveMinttl: make(map[int]int),
+	}
+}
+
+// add records remaining negative ttl, serial and minimum of the response
+func (s *soaStats) add(msmid int, msg *mdns.Msg) {
+	rr := soa(msg.Ns)
+	if rr == nil {
+		return
+	}
+
+	s.access.Lock()
+	defer s.access.Unlock()
+
+	if _, ok := s.ttl[msmid]; !ok {
+		s.ttl[msmid] = newDistribution()
+		s.minttl[msmid] = newDistribution()
+		s.serial[msmid] = make(map[uint32]int)
+	}
+	s.ttl[msmid].add(float64(rr.Hdr.Ttl))
+	s.minttl[msmid].add(float64(rr.Minttl))
+	s.serial[msmid][rr.Serial]++
+
+	// RFC 2308, the negative ttl must not be larger than the minimum
+	if rr.Hdr.Ttl > rr.Minttl {
+		s.aboveMinttl[msmid]++
+	}
+}
+
+// addPoints adds the ttl distributions and the serial counts of every
+// measurement. The distributions start over after each call.
+func (s *soaStats) addPoints(bp *Batch, name string, now time.Time) {
+	s.access.Lock()
+	defer s.access.Unlock()
+
+	for msmid := range s.ttl {
+		tags := map[string]string{"msm_id": strconv.Itoa(msmid)}
+
+		fields := s.ttl[msmid].fields("ttl_")
+		for k, v := range s.minttl[msmid].fields("minttl_") {
+			fields[k] = v
+		}
+		fields["above_minttl"] = s.aboveMinttl[msmid]
+		addPoint(bp, name, tags, fields, now)
+
+		for serial, count := range s.serial[msmid] {
+			tags := map[string]string{
+				"msm_id": strconv.Itoa(msmid),
+				"serial": strconv.FormatUint(uint64(serial), 10),
+			}
+			addPoint(bp, name+"Serial", tags, map[string]interface{}{"count": count}, now)
+		}
+		s.serial[msmid] = make(map[uint32]int)
+	}
+}
@@ -0,0 +1,185 @@
+package cmd
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+	_ "modernc.org/sqlite"
+)
+
+// sqliteMigrations are applied in order, the index + 1 is the schema version
+var sqliteMigrations = []string{
+	`CREATE TABLE responses (
+		id          INTEGER PRIMARY KEY,
+		msm_id      INTEGER NOT NULL,
+		role        TEXT NOT NULL,
+		prb_id      INTEGER NOT NULL,
+		timestamp   INTEGER NOT NULL,
+		dst         TEXT,
+		af          INTEGER,
+		rcode       TEXT,
+		denial      TEXT,
+		flags       TEXT,
+		neg_ttl     INTEGER,
+		soa_minimum INTEGER,
+		rt          REAL,
+		size        INTEGER,
+		abuf        TEXT
+	);
+	CREATE INDEX responses_timestamp ON responses (timestamp);
+	CREATE INDEX responses_probe ON responses (prb_id, timestamp);
+	CREATE INDEX responses_msm ON responses (msm_id, timestamp);`,
+}
+
+// sqliteSink keeps every response in a SQLite database
+type sqliteSink struct {
+	access    sync.Mutex
+	db        *sql.DB
+	retention time.Duration
+	pruned    time.Time
+}
+
+func init() {
+	sinkTypes["sqlite"] = newSQLiteSink
+}
+
+// newSQLiteSink opens the database in file and applies missing migrations.
+// Responses older than retention are removed (0=keep forever).
+func newSQLiteSink(name string, conf *viper.Viper) (Sink, error) {
+	if len(conf.GetString("file")) == 0 {
+		return nil, fmt.Errorf("file must be given")
+	}
+	db, err := openSQLite(conf.GetString("file"))
+	if err != nil {
+		return nil, err
+	}
+	if err = migrateSQLite(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &sqliteSink{db: db, retention: conf.GetDuration("retention")}, nil
+}
+
+// openSQLite opens a database file
+func openSQLite(file string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", file)
+	if err != nil {
+		return nil, err
+	}
+	// sqlite does not like concurrent writers
+	db.SetMaxOpenConns(1)
+	if _, err = db.Exec("PRAGMA journal_mode=WAL"); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// openSQLiteReadOnly opens an existing database file for reading,
+// the schema and journal mode are left as they are
+func openSQLiteReadOnly(file string) (*sql.DB, error) {
+	if _, err := os.Stat(file); err != nil {
+		return nil, err
+	}
+	u := url.URL{Path: file}
+	return sql.Open("sqlite", "file:"+u.EscapedPath()+"?mode=ro")
+}
+
+// migrateSQLite brings the schema to the latest version
+func migrateSQLite(db *sql.DB) error {
+	if _, err := db.Exec("CREATE TABLE IF NOT EXISTS schema_version (version INTEGER NOT NULL)"); err != nil {
+		return err
+	}
+	var version int
+	if err := db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_version").Scan(&version); err != nil {
+		return err
+	}
+
+	for v := version; v < len(sqliteMigrations); v++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err = tx.Exec(sqliteMigrations[v]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %s", v+1, err)
+		}
+		if _, err = tx.Exec("INSERT INTO schema_version (version) VALUES (?)", v+1); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err = tx.Commit(); err != nil {
+			return err
+		}
+		if verbose > 0 {
+			log.Printf("Database migrated to version %d", v+1)
+		}
+	}
+	return nil
+}
+
+// Write ignores the aggregated points
+func (s *sqliteSink) Write(points []*Point) error {
+	return nil
+}
+
+// WriteResponses inserts the responses in one transaction
+func (s *sqliteSink) WriteResponses(responses []*Response) error {
+	s.access.Lock()
+	defer s.access.Unlock()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare(`INSERT INTO responses
+		(msm_id, role, prb_id, timestamp, dst, af, rcode, denial, flags, neg_ttl, soa_minimum, rt, size, abuf)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+
+	for _, r := range responses {
+		_, err = stmt.Exec(r.MsmID, r.Role, r.PrbID, r.Timestamp.Unix(), r.Dst, r.Af, r.Rcode, r.Denial,
+			r.Flags, r.NegTTL, r.SOAMinimum, r.Rt, r.Size, r.Abuf)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	return s.prune()
+}
+
+// prune removes responses older than the retention, at most once per hour
+func (s *sqliteSink) prune() error {
+	if s.retention <= 0 || time.Since(s.pruned) < time.Hour {
+		return nil
+	}
+	s.pruned = time.Now()
+
+	res, err := s.db.Exec("DELETE FROM responses WHERE timestamp < ?", time.Now().Add(-s.retention).Unix())
+	if err != nil {
+		return err
+	}
+	if verbose > 1 {
+		n, _ := res.RowsAffected()
+		log.Printf("Pruned %d responses", n)
+	}
+	return nil
+}
+
+func (s *sqliteSink) Close() error {
+	return s.db.Close()
+}
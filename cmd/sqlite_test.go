@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func testSQLiteSink(t *testing.T, file string, retention time.Duration) *sqliteSink {
+	conf := viper.New()
+	conf.Set("file", file)
+	conf.Set("retention", retention)
+	s, err := newSQLiteSink("test", conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s.(*sqliteSink)
+}
+
+func countResponses(t *testing.T, s *sqliteSink) int {
+	var n int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM responses").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestSQLiteMigrations(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "responses.db")
+
+	s := testSQLiteSink(t, file, 0)
+	var version int
+	if err := s.db.QueryRow("SELECT MAX(version) FROM schema_version").Scan(&version); err != nil {
+		t.Fatal(err)
+	}
+	if version != len(sqliteMigrations) {
+		t.Errorf("got version %d, want %d", version, len(sqliteMigrations))
+	}
+	if err := s.WriteResponses([]*Response{{MsmID: 1, Role: "static", PrbID: 2, Timestamp: time.Now(), Rcode: "NOERROR"}}); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	// reopening must not apply the migrations again
+	s = testSQLiteSink(t, file, 0)
+	defer s.Close()
+	var n int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM schema_version").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != len(sqliteMigrations) {
+		t.Errorf("got %d schema versions, want %d", n, len(sqliteMigrations))
+	}
+	if got := countResponses(t, s); got != 1 {
+		t.Errorf("got %d responses, want 1", got)
+	}
+}
+
+func TestSQLiteRetention(t *testing.T) {
+	s := testSQLiteSink(t, filepath.Join(t.TempDir(), "responses.db"), 24*time.Hour)
+	defer s.Close()
+
+	now := time.Now()
+	responses := []*Response{
+		{MsmID: 1, Role: "static", PrbID: 1, Timestamp: now.Add(-48 * time.Hour)},
+		{MsmID: 1, Role: "static", PrbID: 2, Timestamp: now.Add(-25 * time.Hour)},
+		{MsmID: 1, Role: "static", PrbID: 3, Timestamp: now.Add(-time.Hour)},
+		{MsmID: 1, Role: "static", PrbID: 4, Timestamp: now},
+	}
+	if err := s.WriteResponses(responses); err != nil {
+		t.Fatal(err)
+	}
+	if got := countResponses(t, s); got != 2 {
+		t.Errorf("got %d responses after pruning, want 2", got)
+	}
+
+	// pruning runs at most once per hour
+	if err := s.WriteResponses(responses[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if got := countResponses(t, s); got != 3 {
+		t.Errorf("got %d responses, want 3", got)
+	}
+	s.pruned = time.Time{}
+	if err := s.WriteResponses(nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := countResponses(t, s); got != 2 {
+		t.Errorf("got %d responses after second pruning, want 2", got)
+	}
+}
+
+func TestSQLiteKeepForever(t *testing.T) {
+	s := testSQLiteSink(t, filepath.Join(t.TempDir(), "responses.db"), 0)
+	defer s.Close()
+
+	if err := s.WriteResponses([]*Response{{MsmID: 1, Role: "static", Timestamp: time.Unix(0, 0)}}); err != nil {
+		t.Fatal(err)
+	}
+	if got := countResponses(t, s); got != 1 {
+		t.Errorf("got %d responses, want 1", got)
+	}
+}
+
+func TestSQLiteReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := openSQLiteReadOnly(filepath.Join(dir, "missing.db")); err == nil {
+		t.Error("expected error for missing database")
+	}
+
+	file := filepath.Join(dir, "with space.db")
+	s := testSQLiteSink(t, file, 0)
+	if err := s.WriteResponses([]*Response{{MsmID: 1, Role: "static", Timestamp: time.Now()}}); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	db, err := openSQLiteReadOnly(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM responses").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("got %d responses, want 1", n)
+	}
+	if _, err := db.Exec("DELETE FROM responses"); err == nil {
+		t.Error("expected error writing to read-only database")
+	}
+	if _, err := db.Exec("CREATE TABLE x (y INTEGER)"); err == nil {
+		t.Error("expected error creating table in read-only database")
+	}
+}
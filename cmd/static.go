@@ -24,18 +24,13 @@ import (
 	"github.com/DNS-OARC/ripeatlas/measurement"
 	"github.com/DNS-OARC/ripeatlas/measurement/dns"
 
-	_ "github.com/influxdata/influxdb1-client" // this is important because of the bug in go mod
-	client "github.com/influxdata/influxdb1-client/v2"
-
-	mdns "github.com/miekg/dns"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
-var statsStaticRcode [32]int
-var statsStaticNSEC int
-var statsStaticNSEC3 int
-var statsStaticNONSEC int
+var statsStatic = newProbeCounts()
+var statsStaticAD = newADStats()
+var statsStaticSOA = newSOAStats()
 
 // staticCmd represents the static command
 var staticCmd = &cobra.Command{
@@ -83,12 +78,7 @@ func runStatic(cmd *cobra.Command, args []string) {
 }
 
 func checkStaticConf() {
-	if len(viper.GetString("influxserver")) == 0 {
-		log.Println("Influx server must be given")
-	}
-	if len(viper.GetString("influxdb")) == 0 {
-		log.Println("Influx database must be given")
-	}
+	checkSinkConf()
 }
 
 func rcvStatic(ch <-chan *measurement.Result) {
@@ -99,6 +89,7 @@ func rcvStatic(ch <-chan *measurement.Result) {
 		// if parsing fails
 		if msm.ParseError != nil {
 			log.Println(msm.ParseError.Error())
+			addError("static", PARSEERROR)
 			continue
 		}
 
@@ -113,114 +104,50 @@ func rcvStatic(ch <-chan *measurement.Result) {
 			log.Printf("%d %s", msm.MsmId(), msm.Type())
 		}
 
-		// handle single result
-		if msm.DnsResult() != nil {
-			handleStatic(msm.DnsResult())
-		}
-		for _, s := range msm.DnsResultsets() {
-			if s.Result() != nil {
-				handleStatic(s.Result())
-			}
-		}
+		// handle the result once its window is closed
+		addResult(msm, handleStatic)
 	}
 
 }
 
-func handleStatic(result *dns.Result) {
+func handleStatic(msm *measurement.Result, dst string, result *dns.Result) {
 
 	msg, err := result.UnpackAbuf()
 	if err != nil {
 		log.Println("Could not unpack Abuf ", err)
+		addError("static", ABUFERROR)
 		return
 	}
-	statsStaticRcode[msg.Rcode]++
-	switch nsec(msg.Ns) {
-	case NSEC:
-		statsStaticNSEC++
-	case NSEC3:
-		statsStaticNSEC3++
-	case NONSEC:
-		statsStaticNONSEC++
-	}
+	storeResponse("static", msm, dst, result, msg)
+	statsStatic.add(msm.PrbId(), msg)
+	addResolver("static", msm.PrbId(), msm.Af(), dst, msg)
+	addRtt("static", msm.PrbId(), msm.Af(), dst, result.Rt())
+	addSize("static", msm.MsmId(), result.Size(), msg)
+	statsStaticAD.add(msm.PrbId(), msg)
+	statsStaticSOA.add(msm.MsmId(), msg)
 }
 
 func saveStaticStats() {
-	// influxdb client config
-	conf := client.HTTPConfig{
-		Addr: viper.GetString("influxserver"),
-	}
-	if len(viper.GetString("influxuser")) > 0 {
-		conf.Username = viper.GetString("influxuser")
-		conf.Password = viper.GetString("influxpasswd")
-	}
-
-	// get access to Influx
-	influx, err := client.NewHTTPClient(conf)
-	if err != nil {
-		log.Fatalf("Error creating InfluxDB Client: %s", err.Error())
-	}
-
-	ticker := time.NewTicker(15 * time.Second)
-	for {
-		now := <-ticker.C
-
-		bp, err := client.NewBatchPoints(client.BatchPointsConfig{
-			Database:  viper.GetString("influxdb"),
-			Precision: "s",
-		})
-		if err != nil {
-			log.Fatalf("Could not create new batch points: %s", err.Error())
-		}
+	saveStats(func(bp *Batch, now time.Time) {
+		// rcodes and denial types
+		statsStatic.addPoints(bp, "static", true, now)
 
-		// tags
-		tags := map[string]string{}
+		// ad bit per denial type
+		statsStaticAD.addPoints(bp, "staticAD", now)
 
-		// values
-		fields := map[string]interface{}{}
-		for _, rcode := range RCODES {
-			fields[mdns.RcodeToString[rcode]] = statsStaticRcode[rcode]
-		}
-
-		// create new point
-		pt, err := client.NewPoint("staticRcodes", tags, fields, now)
-		if err != nil {
-			log.Fatal("Could not create new point. ", err)
-		}
-		if verbose > 2 {
-			log.Printf("Tags:   %v\n", tags)
-			log.Printf("Fields: %v\n", fields)
-		}
+		// negative ttl and soa
+		statsStaticSOA.addPoints(bp, "staticSOA", now)
 
-		// add point to list
-		bp.AddPoint(pt)
-
-		// values
-		fields = map[string]interface{}{
-			"nsec":   statsStaticNSEC,
-			"nsec3":  statsStaticNSEC3,
-			"nonsec": statsStaticNONSEC,
-		}
+		// per resolver
+		addResolverPoints(bp, "static", now)
 
-		// create new point
-		pt, err = client.NewPoint("staticNsec", tags, fields, now)
-		if err != nil {
-			log.Fatal("Could not create new point. ", err)
-		}
-		if verbose > 2 {
-			log.Printf("Tags:   %v\n", tags)
-			log.Printf("Fields: %v\n", fields)
-		}
+		// response times
+		addRttPoints(bp, "static", now)
 
-		// add point to list
-		bp.AddPoint(pt)
+		// response sizes and edns
+		addSizePoints(bp, "static", now)
 
-		// write to database
-		if verbose > 1 {
-			log.Println("Writing to influx")
-		}
-		err = influx.Write(bp)
-		if err != nil {
-			log.Printf("Error writing to InfluxDB: %s", err.Error())
-		}
-	}
+		// errors
+		addErrorPoints(bp, "static", now)
+	})
 }
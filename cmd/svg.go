@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"fmt"
+	"html/template"
+	"math"
+	"strings"
+	"time"
+)
+
+// svgSeries is one line of a chart
+type svgSeries struct {
+	Name   string
+	Values []float64
+}
+
+// svgColors are used for the series in order
+var svgColors = []string{"#1f77b4", "#ff7f0e", "#2ca02c", "#d62728", "#9467bd", "#8c564b", "#e377c2"}
+
+const (
+	svgWidth  = 800
+	svgHeight = 240
+	svgLeft   = 60
+	svgRight  = 140
+	svgTop    = 20
+	svgBottom = 30
+)
+
+// svgChart draws a line chart, times are the x values of all series
+func svgChart(times []time.Time, series []svgSeries, unit string) template.HTML {
+	var b strings.Builder
+
+	max := 0.0
+	for _, s := range series {
+		for _, v := range s.Values {
+			max = math.Max(max, v)
+		}
+	}
+	if max == 0 {
+		max = 1
+	}
+	plotW := float64(svgWidth - svgLeft - svgRight)
+	plotH := float64(svgHeight - svgTop - svgBottom)
+	x := func(i int) float64 {
+		if len(times) < 2 {
+			return svgLeft
+		}
+		return svgLeft + plotW*float64(i)/float64(len(times)-1)
+	}
+	y := func(v float64) float64 {
+		return svgTop + plotH - plotH*v/max
+	}
+
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`, svgWidth, svgHeight, svgWidth, svgHeight)
+
+	// axes
+	fmt.Fprintf(&b, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="#999"/>`, svgLeft, svgTop, svgLeft, svgHeight-svgBottom)
+	fmt.Fprintf(&b, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="#999"/>`, svgLeft, svgHeight-svgBottom, svgWidth-svgRight, svgHeight-svgBottom)
+	fmt.Fprintf(&b, `<text x="%d" y="%d" font-size="11" text-anchor="end">%s%s</text>`, svgLeft-4, svgTop+4, number2s(max), unit)
+	fmt.Fprintf(&b, `<text x="%d" y="%d" font-size="11" text-anchor="end">0</text>`, svgLeft-4, svgHeight-svgBottom)
+	if len(times) > 0 {
+		fmt.Fprintf(&b, `<text x="%d" y="%d" font-size="11">%s</text>`, svgLeft, svgHeight-8, times[0].UTC().Format("2006-01-02 15:04"))
+		fmt.Fprintf(&b, `<text x="%d" y="%d" font-size="11" text-anchor="end">%s</text>`, svgWidth-svgRight, svgHeight-8, times[len(times)-1].UTC().Format("2006-01-02 15:04"))
+	}
+
+	// lines and legend
+	for i, s := range series {
+		color := svgColors[i%len(svgColors)]
+		points := make([]string, 0, len(s.Values))
+		for j, v := range s.Values {
+			points = append(points, fmt.Sprintf("%.1f,%.1f", x(j), y(v)))
+		}
+		fmt.Fprintf(&b, `<polyline fill="none" stroke="%s" stroke-width="1.5" points="%s"/>`, color, strings.Join(points, " "))
+		fmt.Fprintf(&b, `<rect x="%d" y="%d" width="10" height="10" fill="%s"/>`, svgWidth-svgRight+10, svgTop+i*16, color)
+		fmt.Fprintf(&b, `<text x="%d" y="%d" font-size="11">%s</text>`, svgWidth-svgRight+24, svgTop+i*16+9, template.HTMLEscapeString(s.Name))
+	}
+
+	b.WriteString(`</svg>`)
+	return template.HTML(b.String())
+}
+
+// number2s formats an axis label
+func number2s(v float64) string {
+	if v == math.Trunc(v) {
+		return fmt.Sprintf("%d", int64(v))
+	}
+	return fmt.Sprintf("%.1f", v)
+}
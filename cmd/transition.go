@@ -0,0 +1,396 @@
+/*
+Copyright © 2020 Ulrich Wisser <ulrich@wisser.se>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DNS-OARC/ripeatlas/measurement"
+	"github.com/DNS-OARC/ripeatlas/measurement/dns"
+
+	mdns "github.com/miekg/dns"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	RESOLVER = "resolver"
+	AUTH     = "auth"
+	OLD      = "old"
+	NEW      = "new"
+	OTHER    = "other"
+)
+
+// denialScheme describes the denial of existence used by a zone
+type denialScheme struct {
+	Type       string
+	Hash       uint8
+	Iterations uint16
+	Salt       string
+	Params     bool
+}
+
+// transitionState is the state of one resolver or path to an
+// authoritative server during the transition
+type transitionState struct {
+	Kind      string
+	PrbID     int
+	Af        int
+	Target    string
+	Current   string
+	Converged time.Time
+	LastOld   time.Time
+	LastSeen  time.Time
+}
+
+// stragglerKey identifies the stragglers of a probe, resolver
+// addresses are bucketed like the resolver statistics
+type stragglerKey struct {
+	PrbID  int
+	Af     int
+	Target string
+}
+
+var switchTime time.Time
+var schemeFrom denialScheme
+var schemeTo denialScheme
+var authMeasurements = make(map[int]bool)
+var transitions = make(map[string]*transitionState)
+var accessTransitions = sync.Mutex{}
+
+// transitionCmd represents the transition command
+var transitionCmd = &cobra.Command{
+	Use:   "transition",
+	Short: "track the transition of a zone between NSEC and NSEC3",
+	Long: `track the transition of a zone between NSEC and NSEC3
+
+Denial parameters are given as NSEC, NSEC3 or NSEC3:<hash>:<iterations>:<salt>
+where "-" is the empty salt.`,
+	Run: runTransition,
+}
+
+func init() {
+	rootCmd.AddCommand(transitionCmd)
+	transitionCmd.Flags().String("switch", "", "time and date of the switch-over")
+	transitionCmd.Flags().String("from", "", "denial parameters before the switch-over")
+	transitionCmd.Flags().String("to", "", "denial parameters after the switch-over")
+	transitionCmd.Flags().IntSlice("auth", []int{}, "measurement ids that query the authoritative servers")
+	transitionCmd.Flags().Bool("stragglertable", false, "write one point per straggler")
+
+	// Use flags for viper values
+	viper.BindPFlags(transitionCmd.Flags())
+}
+
+func runTransition(cmd *cobra.Command, args []string) {
+	var measurements = make([]int, 0)
+
+	// check config
+	checkTransitionConf()
+
+	// check arguments
+	if len(args) == 0 {
+		log.Fatal("At least one measurement id must be given")
+	}
+
+	// convert arguments
+	for _, m := range args {
+		v, err := strconv.Atoi(m)
+		if err != nil {
+			log.Fatal("Could not convert to int: ", m)
+		}
+		measurements = append(measurements, v)
+	}
+	for _, m := range viper.GetIntSlice("auth") {
+		authMeasurements[m] = true
+		measurements = append(measurements, m)
+	}
+
+	ch := subscribe(measurements)
+
+	go rcvTransition(ch)
+	go rcvTransition(ch)
+
+	go saveTransitionStats()
+
+	select {}
+}
+
+func checkTransitionConf() {
+	var err error
+
+	checkSinkConf()
+
+	switchTime, err = time.Parse(time.RFC3339, viper.GetString("switch"))
+	if err != nil {
+		log.Fatal("Could not parse switch-over time. ", err)
+	}
+	schemeFrom, err = parseScheme(viper.GetString("from"))
+	if err != nil {
+		log.Fatal("Could not parse from. ", err)
+	}
+	schemeTo, err = parseScheme(viper.GetString("to"))
+	if err != nil {
+		log.Fatal("Could not parse to. ", err)
+	}
+	if schemeFrom == schemeTo {
+		log.Fatal("from and to must be different")
+	}
+
+	// debug output
+	if verbose > 0 {
+		log.Println("Switch-over: ", switchTime)
+		log.Println("From:        ", viper.GetString("from"))
+		log.Println("To:          ", viper.GetString("to"))
+	}
+}
+
+// parseScheme parses NSEC, NSEC3 or NSEC3:<hash>:<iterations>:<salt>
+func parseScheme(s string) (denialScheme, error) {
+	parts := strings.Split(strings.ToUpper(s), ":")
+	switch {
+	case len(parts) == 1 && (parts[0] == NSEC || parts[0] == NSEC3):
+		return denialScheme{Type: parts[0]}, nil
+	case len(parts) == 4 && parts[0] == NSEC3:
+		hash, err := strconv.ParseUint(parts[1], 10, 8)
+		if err != nil {
+			return denialScheme{}, err
+		}
+		iterations, err := strconv.ParseUint(parts[2], 10, 16)
+		if err != nil {
+			return denialScheme{}, err
+		}
+		salt := parts[3]
+		if salt == "-" {
+			salt = ""
+		}
+		return denialScheme{Type: NSEC3, Hash: uint8(hash), Iterations: uint16(iterations), Salt: salt, Params: true}, nil
+	}
+	return denialScheme{}, fmt.Errorf("unknown denial parameters %s", s)
+}
+
+// responseScheme returns the denial of existence used in the rrset
+func responseScheme(rrset []mdns.RR) denialScheme {
+	for _, rr := range rrset {
+		switch r := rr.(type) {
+		case *mdns.NSEC:
+			return denialScheme{Type: NSEC}
+		case *mdns.NSEC3:
+			return denialScheme{Type: NSEC3, Hash: r.Hash, Iterations: r.Iterations, Salt: strings.ToUpper(r.Salt), Params: true}
+		}
+	}
+	return denialScheme{Type: NONSEC}
+}
+
+// matches checks if the response uses the scheme,
+// NSEC3 parameters are only compared if given
+func (d denialScheme) matches(r denialScheme) bool {
+	if d.Type != r.Type {
+		return false
+	}
+	if d.Params {
+		return d.Hash == r.Hash && d.Iterations == r.Iterations && d.Salt == r.Salt
+	}
+	return true
+}
+
+func rcvTransition(ch <-chan *measurement.Result) {
+	for msm := range ch {
+
+		// if parsing fails
+		if msm.ParseError != nil {
+			log.Println(msm.ParseError.Error())
+			addError("transition", PARSEERROR)
+			continue
+		}
+
+		// we handle only dns results
+		if msm.Type() != "dns" {
+			log.Printf("Wrong result type msmid %d type %s", msm.MsmId(), msm.Type())
+			return
+		}
+
+		// debug output of received result
+		if verbose > 2 {
+			log.Printf("%d %s", msm.MsmId(), msm.Type())
+		}
+
+		// handle the result once its window is closed
+		addResult(msm, handleTransition)
+	}
+}
+
+// handleTransition updates the state of the resolver or, for authoritative
+// measurements, the path from the probe to the server
+func handleTransition(msm *measurement.Result, dst string, result *dns.Result) {
+
+	msg, err := result.UnpackAbuf()
+	if err != nil {
+		log.Println("Could not unpack Abuf ", err)
+		addError("transition", ABUFERROR)
+		return
+	}
+	storeResponse("transition", msm, dst, result, msg)
+
+	scheme := responseScheme(msg.Ns)
+	answer := OTHER
+	if schemeFrom.matches(scheme) {
+		answer = OLD
+	}
+	if schemeTo.matches(scheme) {
+		answer = NEW
+	}
+
+	kind := RESOLVER
+	target := dst
+	key := fmt.Sprintf("%s %d %s", RESOLVER, msm.PrbId(), dst)
+	if authMeasurements[msm.MsmId()] {
+		kind = AUTH
+		target = server(msm)
+		key = fmt.Sprintf("%s %s %d %d", AUTH, server(msm), msm.Af(), msm.PrbId())
+	}
+	ts := time.Unix(int64(msm.Timestamp()), 0)
+
+	accessTransitions.Lock()
+	defer accessTransitions.Unlock()
+
+	st, ok := transitions[key]
+	if !ok {
+		st = &transitionState{Kind: kind, PrbID: msm.PrbId(), Af: msm.Af(), Target: target}
+		transitions[key] = st
+	}
+	if ts.Before(st.LastSeen) {
+		// results may arrive out of order
+		if answer == OLD && ts.After(st.LastOld) {
+			st.LastOld = ts
+		}
+		return
+	}
+	st.LastSeen = ts
+
+	switch answer {
+	case OLD:
+		st.LastOld = ts
+		st.Converged = time.Time{}
+	case NEW:
+		// paths that are new before the switch or when first seen
+		// converged at the switch or at the first new answer
+		if st.Converged.IsZero() {
+			st.Converged = ts
+			if ts.Before(switchTime) {
+				st.Converged = switchTime
+			}
+		}
+	}
+	// other answers do not change a path that answered old or new
+	if answer != OTHER || st.Current == "" {
+		st.Current = answer
+	}
+}
+
+func saveTransitionStats() {
+	saveStats(func(bp *Batch, now time.Time) {
+		accessTransitions.Lock()
+		converge := map[string][]float64{RESOLVER: {}, AUTH: {}}
+		count := map[string]map[string]int{RESOLVER: {}, AUTH: {}}
+		stragglers := map[string][]transitionState{RESOLVER: {}, AUTH: {}}
+		lastOld := map[string]time.Time{}
+		for key, st := range transitions {
+			count[st.Kind][st.Current]++
+			if !st.Converged.IsZero() {
+				converge[st.Kind] = append(converge[st.Kind], st.Converged.Sub(switchTime).Seconds())
+			}
+
+			// stragglers still serve the old records after the switch
+			if st.Current == OLD && st.LastOld.After(switchTime) {
+				count[st.Kind]["stragglers"]++
+				stragglers[st.Kind] = append(stragglers[st.Kind], *st)
+				if st.LastOld.After(lastOld[st.Kind]) {
+					lastOld[st.Kind] = st.LastOld
+				}
+				if verbose > 1 {
+					log.Printf("Straggler %s last old answer at %s", key, st.LastOld)
+				}
+			}
+		}
+		accessTransitions.Unlock()
+
+		for _, kind := range []string{RESOLVER, AUTH} {
+			tags := map[string]string{"kind": kind}
+
+			// values
+			fields := map[string]interface{}{
+				"old":        count[kind][OLD],
+				"new":        count[kind][NEW],
+				"other":      count[kind][OTHER],
+				"stragglers": count[kind]["stragglers"],
+			}
+			sort.Float64s(converge[kind])
+			if len(converge[kind]) > 0 {
+				fields["converge_p50"] = quantile(converge[kind], 0.5)
+				fields["converge_p90"] = quantile(converge[kind], 0.9)
+				fields["converge_p99"] = quantile(converge[kind], 0.99)
+				fields["converge_max"] = converge[kind][len(converge[kind])-1]
+			}
+			addPoint(bp, "transition", tags, fields, now)
+
+			// stragglers
+			if len(stragglers[kind]) > 0 {
+				fields = map[string]interface{}{
+					"count":    len(stragglers[kind]),
+					"last_old": lastOld[kind].Unix(),
+				}
+				addPoint(bp, "transitionStraggler", tags, fields, now)
+			}
+			// with "stragglertable" one point per probe,
+			// private resolver addresses are bucketed
+			if !viper.GetBool("stragglertable") {
+				continue
+			}
+			probes := make(map[stragglerKey]int)
+			last := make(map[stragglerKey]time.Time)
+			for _, st := range stragglers[kind] {
+				k := stragglerKey{PrbID: st.PrbID, Af: st.Af, Target: st.Target}
+				if kind == RESOLVER {
+					k.Target = resolverName(st.Target)
+				}
+				probes[k]++
+				if st.LastOld.After(last[k]) {
+					last[k] = st.LastOld
+				}
+			}
+			for k, n := range probes {
+				tags := map[string]string{
+					"kind":   kind,
+					"prb_id": strconv.Itoa(k.PrbID),
+					"af":     strconv.Itoa(k.Af),
+					"target": k.Target,
+				}
+				fields := map[string]interface{}{"count": n, "last_old": last[k].Unix()}
+				addPoint(bp, "transitionStragglerProbe", tags, fields, now)
+			}
+		}
+
+		// errors
+		addErrorPoints(bp, "transition", now)
+	})
+}
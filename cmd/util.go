@@ -1,6 +1,12 @@
 package cmd
 
 import (
+	"encoding/hex"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
 	mdns "github.com/miekg/dns"
 )
 
@@ -16,3 +22,73 @@ func nsec(rrset []mdns.RR) string {
 	}
 	return NONSEC
 }
+
+// addPoint creates a new point and adds it to the batch
+func addPoint(bp *Batch, name string, tags map[string]string, fields map[string]interface{}, now time.Time) {
+	if verbose > 2 {
+		log.Printf("Tags:   %v\n", tags)
+		log.Printf("Fields: %v\n", fields)
+	}
+
+	// add point to list
+	bp.Points = append(bp.Points, &Point{Name: name, Tags: tags, Fields: fields, Time: now})
+}
+
+// soa returns the first SOA record of the rrset or nil
+func soa(rrset []mdns.RR) *mdns.SOA {
+	for _, rr := range rrset {
+		if s, ok := rr.(*mdns.SOA); ok {
+			return s
+		}
+	}
+	return nil
+}
+
+// denialSignature returns a string that identifies the NSEC or NSEC3
+// records of the rrset, independent of the query name
+func denialSignature(rrset []mdns.RR) string {
+	proofs := make([]string, 0)
+	for _, rr := range rrset {
+		switch r := rr.(type) {
+		case *mdns.NSEC:
+			proofs = append(proofs, strings.ToLower(r.Hdr.Name+" "+r.NextDomain))
+		case *mdns.NSEC3:
+			proofs = append(proofs, strings.ToLower(r.Hdr.Name+" "+r.NextDomain))
+		}
+	}
+	sort.Strings(proofs)
+	return strings.Join(proofs, ",")
+}
+
+// nsid returns the NSID option of the response in readable form
+func nsid(msg *mdns.Msg) string {
+	opt := msg.IsEdns0()
+	if opt == nil {
+		return ""
+	}
+	for _, o := range opt.Option {
+		if n, ok := o.(*mdns.EDNS0_NSID); ok {
+			b, err := hex.DecodeString(n.Nsid)
+			if err != nil {
+				return n.Nsid
+			}
+			return string(b)
+		}
+	}
+	return ""
+}
+
+// ede returns the extended DNS errors of the response, separated by ";"
+func ede(msg *mdns.Msg) string {
+	opt := msg.IsEdns0()
+	if opt == nil {
+		return ""
+	}
+	errors := make([]string, 0)
+	for _, o := range opt.Option {
+		if e, ok := o.(*mdns.EDNS0_EDE); ok {
+			errors = append(errors, e.String())
+		}
+	}
+	return strings.Join(errors, ";")
+}
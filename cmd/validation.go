@@ -0,0 +1,170 @@
+package cmd
+
+import (
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	mdns "github.com/miekg/dns"
+	"github.com/spf13/viper"
+)
+
+const (
+	VALIDATING    = "validating"
+	NONVALIDATING = "nonvalidating"
+	INCONSISTENT  = "inconsistent"
+)
+
+// validationKey identifies the resolver of a probe per address family
+type validationKey struct {
+	PrbID int
+	Af    int
+}
+
+// probeValidation is the rolling validation state of the resolver of one probe
+type probeValidation struct {
+	State       string
+	History     []string
+	Transitions int
+	Changed     time.Time
+	LastSeen    time.Time
+}
+
+var validationState = make(map[validationKey]*probeValidation)
+var statsValidationTransitions int
+var accessValidation = sync.Mutex{}
+
+// classifyValidation decides from a response to the invalid signed domain
+// if the resolver validates. A validating resolver must return SERVFAIL,
+// any other answer (data, NODATA or NXDOMAIN) was not validated. An empty
+// string is returned if the response does not tell us anything.
+func classifyValidation(msg *mdns.Msg) string {
+	return classifyRcode(msg.Rcode)
+}
+
+// classifyRcode is classifyValidation for the rcode of a response
+func classifyRcode(rcode int) string {
+	switch rcode {
+	case mdns.RcodeServerFailure:
+		return VALIDATING
+	case mdns.RcodeSuccess, mdns.RcodeNameError:
+		return NONVALIDATING
+	}
+	return ""
+}
+
+// updateValidation adds an outcome to the history of the probe and
+// recomputes the probe state from the last "validationwindow" outcomes.
+func updateValidation(prbid int, af int, outcome string, ts time.Time) {
+	if len(outcome) == 0 {
+		return
+	}
+	window := viper.GetInt("validationwindow")
+	if window < 1 {
+		window = 1
+	}
+
+	accessValidation.Lock()
+	defer accessValidation.Unlock()
+
+	key := validationKey{PrbID: prbid, Af: af}
+	pv, ok := validationState[key]
+	if !ok {
+		pv = &probeValidation{Changed: ts}
+		validationState[key] = pv
+	}
+	pv.LastSeen = ts
+	pv.History = append(pv.History, outcome)
+	if len(pv.History) > window {
+		pv.History = pv.History[len(pv.History)-window:]
+	}
+
+	state := pv.History[0]
+	for _, h := range pv.History {
+		if h != state {
+			state = INCONSISTENT
+			break
+		}
+	}
+
+	if state == pv.State {
+		return
+	}
+	if len(pv.State) > 0 {
+		pv.Transitions++
+		statsValidationTransitions++
+		if verbose > 1 {
+			log.Printf("Probe %d IPv%d changed from %s to %s", prbid, af, pv.State, state)
+		}
+	}
+	pv.State = state
+	pv.Changed = ts
+}
+
+// validationCount holds the number of probes per state
+type validationCount map[string]int
+
+func (c validationCount) fields() map[string]interface{} {
+	fields := map[string]interface{}{
+		VALIDATING:    c[VALIDATING],
+		NONVALIDATING: c[NONVALIDATING],
+		INCONSISTENT:  c[INCONSISTENT],
+	}
+	total := c[VALIDATING] + c[NONVALIDATING] + c[INCONSISTENT]
+	if total > 0 {
+		fields["share"] = float64(c[VALIDATING]) / float64(total)
+	}
+	return fields
+}
+
+// addValidationPoints adds the probe state counts, globally and per country
+// and asn, to the batch. If "probetable" is set, one point per probe is added.
+func addValidationPoints(bp *Batch, now time.Time) {
+	total := validationCount{}
+	country := make(map[string]validationCount)
+	asn := make(map[int]validationCount)
+
+	accessValidation.Lock()
+	defer accessValidation.Unlock()
+
+	for key, pv := range validationState {
+		total[pv.State]++
+
+		tags := map[string]string{"prb_id": strconv.Itoa(key.PrbID), "af": strconv.Itoa(key.Af)}
+		p := getProbe(key.PrbID)
+		if p != nil {
+			if _, ok := country[p.Country]; !ok {
+				country[p.Country] = validationCount{}
+			}
+			country[p.Country][pv.State]++
+			if _, ok := asn[p.asn(key.Af)]; !ok {
+				asn[p.asn(key.Af)] = validationCount{}
+			}
+			asn[p.asn(key.Af)][pv.State]++
+
+			tags["country"] = p.Country
+			tags["asn"] = strconv.Itoa(p.asn(key.Af))
+		}
+
+		if viper.GetBool("probetable") {
+			fields := map[string]interface{}{
+				"state":       pv.State,
+				"transitions": pv.Transitions,
+				"changed":     pv.Changed.Unix(),
+			}
+			addPoint(bp, "invalidProbe", tags, fields, now)
+		}
+	}
+
+	fields := total.fields()
+	fields["transitions"] = statsValidationTransitions
+	addPoint(bp, "invalidValidation", map[string]string{}, fields, now)
+
+	for cc, c := range country {
+		addPoint(bp, "invalidValidationCountry", map[string]string{"country": cc}, c.fields(), now)
+	}
+	for as, c := range asn {
+		addPoint(bp, "invalidValidationAsn", map[string]string{"asn": strconv.Itoa(as)}, c.fields(), now)
+	}
+}
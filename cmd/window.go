@@ -0,0 +1,169 @@
+package cmd
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/DNS-OARC/ripeatlas/measurement"
+	"github.com/DNS-OARC/ripeatlas/measurement/dns"
+	"github.com/spf13/viper"
+)
+
+// resultHandler handles one dns result of a measurement result
+type resultHandler func(msm *measurement.Result, dst string, result *dns.Result)
+
+// windowResult is a result waiting for its window to be closed
+type windowResult struct {
+	msm    *measurement.Result
+	handle resultHandler
+}
+
+// window is a closed window with its results ordered by timestamp
+type window struct {
+	start   time.Time
+	results []windowResult
+}
+
+var windowPending = make(map[int64][]windowResult)
+var windowOffset int64
+var windowNext int64
+var windowNewest int64
+var windowSeen time.Time
+var windowResults int
+var windowLate int
+var accessWindows = sync.Mutex{}
+
+// windowSize returns the length of a window in seconds
+func windowSize() int64 {
+	size := int64(viper.GetDuration("window").Seconds())
+	if size < 1 {
+		size = 60
+	}
+	return size
+}
+
+// windowStart returns the start of the window of a timestamp,
+// windows start at windowOffset seconds after a multiple of the size
+func windowStart(ts int64) int64 {
+	size := windowSize()
+	return ts - ((ts-windowOffset)%size+size)%size
+}
+
+// addResult queues a result in the window of its timestamp. Results
+// for windows that are already closed are counted as late and dropped.
+// Timestamps in the future are taken as now, a probe with a wrong clock
+// must not move the watermark.
+func addResult(msm *measurement.Result, handle resultHandler) {
+	ts := int64(msm.Timestamp())
+	if now := time.Now().Unix(); ts > now {
+		ts = now
+	}
+	start := windowStart(ts)
+
+	accessWindows.Lock()
+	defer accessWindows.Unlock()
+
+	if windowNext > 0 && start < windowNext {
+		windowLate++
+		if verbose > 1 {
+			log.Printf("Late result msmid %d probe %d timestamp %d", msm.MsmId(), msm.PrbId(), ts)
+		}
+		return
+	}
+	windowPending[start] = append(windowPending[start], windowResult{msm: msm, handle: handle})
+	windowResults++
+	if ts > windowNewest {
+		windowNewest = ts
+	}
+	windowSeen = time.Now()
+}
+
+// closeWindows returns all windows with results that ended before the watermark,
+// oldest first. The watermark is the newest result timestamp, moved on by the
+// wall clock while no results arrive, minus the allowed "lateness". Windows
+// without results are skipped.
+func closeWindows(now time.Time) []*window {
+	accessWindows.Lock()
+	defer accessWindows.Unlock()
+
+	closed := make([]*window, 0)
+	if windowNewest == 0 {
+		// no results yet
+		return closed
+	}
+	if windowNext == 0 {
+		for start := range windowPending {
+			if windowNext == 0 || start < windowNext {
+				windowNext = start
+			}
+		}
+	}
+
+	size := windowSize()
+	watermark := windowNewest + int64(now.Sub(windowSeen).Seconds()) - int64(viper.GetDuration("lateness").Seconds())
+	for windowNext+size <= watermark {
+		if len(windowPending[windowNext]) == 0 {
+			windowNext = nextPending(windowStart(watermark))
+			continue
+		}
+		w := &window{start: time.Unix(windowNext, 0), results: windowPending[windowNext]}
+		sort.SliceStable(w.results, func(i, j int) bool {
+			return w.results[i].msm.Timestamp() < w.results[j].msm.Timestamp()
+		})
+		delete(windowPending, windowNext)
+		closed = append(closed, w)
+		windowNext += size
+	}
+	return closed
+}
+
+// nextPending returns the oldest window with results before limit or limit
+func nextPending(limit int64) int64 {
+	next := limit
+	for start, results := range windowPending {
+		if start >= windowNext && start < next && len(results) > 0 {
+			next = start
+		}
+	}
+	return next
+}
+
+// handle runs the handlers of all results in the window
+func (w *window) handle() {
+	for _, r := range w.results {
+		eachResult(r.msm, r.handle)
+	}
+}
+
+// eachResult calls handle for the single result and every result set
+func eachResult(msm *measurement.Result, handle resultHandler) {
+	if msm.DnsResult() != nil {
+		handle(msm, msm.DstAddr(), msm.DnsResult())
+	}
+	for _, s := range msm.DnsResultsets() {
+		if s.Result() != nil {
+			handle(msm, s.DstAddr(), s.Result())
+		}
+	}
+}
+
+// addWindowPoints adds the number of handled, late and pending results
+func addWindowPoints(bp *Batch, now time.Time) {
+	accessWindows.Lock()
+	defer accessWindows.Unlock()
+
+	pending := 0
+	for _, results := range windowPending {
+		pending += len(results)
+	}
+
+	// values
+	fields := map[string]interface{}{
+		"results": windowResults,
+		"late":    windowLate,
+		"pending": pending,
+	}
+	addPoint(bp, "windows", map[string]string{}, fields, now)
+}
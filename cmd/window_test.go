@@ -0,0 +1,144 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/DNS-OARC/ripeatlas/measurement"
+	"github.com/DNS-OARC/ripeatlas/measurement/dns"
+	"github.com/spf13/viper"
+)
+
+// testResult returns a dns result of a probe at ts
+func testResult(t *testing.T, ts int64, prbid int) *measurement.Result {
+	r := &measurement.Result{}
+	data := fmt.Sprintf(`{"type":"dns","msm_id":1,"prb_id":%d,"timestamp":%d}`, prbid, ts)
+	if err := json.Unmarshal([]byte(data), r); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// resetWindows starts over with minute windows and the given lateness
+func resetWindows(t *testing.T, lateness time.Duration) {
+	window, late := viper.Get("window"), viper.Get("lateness")
+	t.Cleanup(func() {
+		viper.Set("window", window)
+		viper.Set("lateness", late)
+	})
+	viper.Set("window", time.Minute)
+	viper.Set("lateness", lateness)
+
+	accessWindows.Lock()
+	defer accessWindows.Unlock()
+	windowPending = make(map[int64][]windowResult)
+	windowOffset, windowNext, windowNewest = 0, 0, 0
+	windowSeen = time.Time{}
+	windowResults, windowLate = 0, 0
+}
+
+func noHandler(msm *measurement.Result, dst string, result *dns.Result) {}
+
+// starts returns the start and number of results of the windows
+func starts(windows []*window) []string {
+	s := make([]string, len(windows))
+	for i, w := range windows {
+		s[i] = fmt.Sprintf("%d/%d", w.start.Unix(), len(w.results))
+	}
+	return s
+}
+
+func TestCloseWindows(t *testing.T) {
+	resetWindows(t, 0)
+	base := time.Now().Unix()/60*60 - 600
+
+	if w := closeWindows(time.Now()); len(w) != 0 {
+		t.Fatalf("got %d windows without results", len(w))
+	}
+
+	addResult(testResult(t, base+30, 2), noHandler)
+	addResult(testResult(t, base+5, 1), noHandler)
+	addResult(testResult(t, base+65, 3), noHandler)
+
+	// the first window ended before the newest result
+	w := closeWindows(time.Now())
+	if fmt.Sprint(starts(w)) != fmt.Sprintf("[%d/2]", base) {
+		t.Fatalf("got windows %v", starts(w))
+	}
+	if w[0].results[0].msm.PrbId() != 1 || w[0].results[1].msm.PrbId() != 2 {
+		t.Error("results not ordered by timestamp")
+	}
+	if w := closeWindows(time.Now()); len(w) != 0 {
+		t.Fatalf("got windows %v twice", starts(w))
+	}
+
+	// windows without results after a gap are skipped
+	addResult(testResult(t, base+310, 4), noHandler)
+	w = closeWindows(time.Now())
+	if fmt.Sprint(starts(w)) != fmt.Sprintf("[%d/1]", base+60) {
+		t.Fatalf("got windows %v after gap", starts(w))
+	}
+	if windowNext != base+300 {
+		t.Errorf("next window %d, want %d", windowNext, base+300)
+	}
+
+	// late results are dropped
+	addResult(testResult(t, base+100, 5), noHandler)
+	if windowLate != 1 || len(windowPending[base+60]) != 0 {
+		t.Errorf("late result not dropped, %d late", windowLate)
+	}
+
+	// the wall clock moves the watermark while no results arrive
+	if w := closeWindows(time.Now().Add(30 * time.Second)); len(w) != 0 {
+		t.Fatalf("got windows %v too early", starts(w))
+	}
+	w = closeWindows(time.Now().Add(time.Minute))
+	if fmt.Sprint(starts(w)) != fmt.Sprintf("[%d/1]", base+300) {
+		t.Fatalf("got windows %v from wall clock", starts(w))
+	}
+	if windowResults != 4 {
+		t.Errorf("got %d results, want 4", windowResults)
+	}
+}
+
+func TestCloseWindowsLateness(t *testing.T) {
+	resetWindows(t, 2*time.Minute)
+	base := time.Now().Unix()/60*60 - 600
+
+	addResult(testResult(t, base+10, 1), noHandler)
+	addResult(testResult(t, base+130, 2), noHandler)
+	if w := closeWindows(time.Now()); len(w) != 0 {
+		t.Fatalf("got windows %v within lateness", starts(w))
+	}
+
+	// results for open windows are still accepted
+	addResult(testResult(t, base+20, 3), noHandler)
+	addResult(testResult(t, base+190, 4), noHandler)
+	w := closeWindows(time.Now())
+	if fmt.Sprint(starts(w)) != fmt.Sprintf("[%d/2]", base) {
+		t.Fatalf("got windows %v", starts(w))
+	}
+	if windowLate != 0 {
+		t.Errorf("got %d late results", windowLate)
+	}
+}
+
+func TestWindowFutureTimestamp(t *testing.T) {
+	resetWindows(t, 0)
+	now := time.Now().Unix()
+
+	// a probe with a clock a day ahead
+	addResult(testResult(t, now+86400, 1), noHandler)
+	if windowNewest > time.Now().Unix() {
+		t.Fatalf("watermark moved to the future, newest %d", windowNewest)
+	}
+
+	// results of the other probes are not late
+	closeWindows(time.Now())
+	addResult(testResult(t, windowNewest, 2), noHandler)
+	if windowLate != 0 {
+		t.Errorf("got %d late results", windowLate)
+	}
+}